@@ -3,6 +3,7 @@ package vt100
 import (
 	"errors"
 	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
@@ -17,17 +18,47 @@ import (
 // This is a distinct category of errors from things we do know how
 // to do, but are badly encoded, or errors from the underlying io.RuneScanner
 // that we're reading commands from.
+//
+// Use errors.As to get at the details of one.
 type UnsupportedError struct {
-	error
+	// Raw is the sequence as it was written to the terminal or, for a
+	// command passed to Process, as it's encoded.
+	Raw []byte
+
+	// Kind describes what wasn't supported, e.g. "SGR attribute" or
+	// "command 'n'".
+	Kind string
+
+	// Params holds the numeric parameters that weren't supported, if any.
+	Params []int
+
+	err error
+}
+
+func (e UnsupportedError) Error() string {
+	return e.err.Error()
+}
+
+func (e UnsupportedError) Unwrap() error {
+	return e.err
 }
 
-func supportError(e error) error {
-	return UnsupportedError{e}
+func supportError(kind string, params []int, e error) error {
+	return UnsupportedError{Kind: kind, Params: params, err: e}
 }
 
 // Command is a type of object that the terminal can process to perform
 // an update.
+//
+// Commands describe themselves briefly when formatted with %v, e.g. as
+// "CUP(3,1)" or "RUNE('a')", and as a Go literal with %#v.
 type Command interface {
+	fmt.Stringer
+
+	// Encode writes the command to w as bytes which Decode would decode to
+	// the same command.
+	Encode(w io.Writer) error
+
 	display(v *VT100) error
 }
 
@@ -35,6 +66,19 @@ type Command interface {
 // to the current cell and advances the cursor.
 type runeCommand rune
 
+func (r runeCommand) String() string {
+	return fmt.Sprintf("RUNE(%q)", rune(r))
+}
+
+func (r runeCommand) GoString() string {
+	return fmt.Sprintf("runeCommand(%q)", rune(r))
+}
+
+func (r runeCommand) Encode(w io.Writer) error {
+	_, err := io.WriteString(w, string(rune(r)))
+	return err
+}
+
 func (r runeCommand) display(v *VT100) error {
 	v.put(rune(r))
 	return nil
@@ -48,12 +92,151 @@ type escapeCommand struct {
 	args string
 }
 
+// escapeNames are the mnemonics of the escape commands, keyed as they're
+// dispatched: by final byte, prefixed by "?" for private ones or by the
+// intermediate for those that have one.
+var escapeNames = map[string]string{
+	"7":  "DECSC",
+	"8":  "DECRC",
+	"A":  "CUU",
+	"B":  "CUD",
+	"C":  "CUF",
+	"D":  "CUB",
+	"G":  "CHA",
+	"H":  "CUP",
+	"f":  "HVP",
+	"m":  "SGR",
+	"s":  "SCOSC",
+	"u":  "SCORC",
+	"?h": "DECSET",
+	"?l": "DECRST",
+	`"q`: "DECSCA",
+	"(":  "SCS0",
+	")":  "SCS1",
+	"J":  "ERASE",
+	"K":  "ERASE",
+	"?J": "ERASE",
+	"?K": "ERASE",
+}
+
+// String describes c by its mnemonic and arguments, e.g. "CUP(3,1)" or
+// "SGR[1;31]", or "ESC(...)" for one that isn't known.
 func (c escapeCommand) String() string {
-	return fmt.Sprintf("[%q %U](%v)", c.cmd, c.cmd, c.args)
+	args, key := c.args, string(c.cmd)
+	if strings.HasPrefix(args, "?") {
+		args, key = args[1:], "?"+key
+	} else if params, i, ok := c.intermediate(); ok {
+		args, key = params, i+key
+	}
+
+	name, ok := escapeNames[key]
+	switch {
+	case !ok:
+		return fmt.Sprintf("ESC(%q %q)", c.args, c.cmd)
+	case name == "SGR":
+		return "SGR[" + args + "]"
+	case name == "ERASE":
+		return eraseString(key, args)
+	}
+	return name + "(" + strings.Replace(args, ";", ",", -1) + ")"
+}
+
+// eraseString describes an erase command, e.g. "ERASE(line,forward)".
+func eraseString(key, args string) string {
+	what := "display"
+	if strings.HasSuffix(key, "K") {
+		what = "line"
+	}
+	dir := args
+	switch args {
+	case "", "0":
+		dir = "forward"
+	case "1":
+		dir = "back"
+	case "2":
+		dir = "all"
+	}
+	if strings.HasPrefix(key, "?") {
+		return "ERASE(" + what + "," + dir + ",selective)"
+	}
+	return "ERASE(" + what + "," + dir + ")"
+}
+
+// Encode writes c as a CSI sequence if it ends in a CSI final byte, and an
+// ESC sequence otherwise. ESC sequences with such a final byte, and no
+// arguments, decode to the same command either way.
+func (c escapeCommand) Encode(w io.Writer) error {
+	var err error
+	if c.cmd >= 0x40 && c.cmd <= 0x7e {
+		_, err = io.WriteString(w, "\x1b["+c.args+string(c.cmd))
+	} else {
+		_, err = io.WriteString(w, "\x1b"+string(c.cmd)+c.args)
+	}
+	return err
+}
+
+func (c escapeCommand) GoString() string {
+	return fmt.Sprintf("escapeCommand{%q, %q}", c.cmd, c.args)
+}
+
+// stringCommand is a control string, such as an OSC or DCS sequence, which
+// carries arbitrary data rather than numeric arguments.
+type stringCommand struct {
+	// kind is the rune that introduced the string, e.g. ']' for OSC.
+	kind rune
+	data string
+}
+
+func (c stringCommand) String() string {
+	name := "STRING"
+	switch c.kind {
+	case ']':
+		name = "OSC"
+	case 'P':
+		name = "DCS"
+	case 'X':
+		name = "SOS"
+	case '^':
+		name = "PM"
+	case '_':
+		name = "APC"
+	}
+	return fmt.Sprintf("%s(%q)", name, c.data)
+}
+
+// Encode writes c terminated by ST.
+func (c stringCommand) Encode(w io.Writer) error {
+	_, err := io.WriteString(w, "\x1b"+string(c.kind)+c.data+"\x1b\\")
+	return err
+}
+
+func (c stringCommand) GoString() string {
+	return fmt.Sprintf("stringCommand{%q, %q}", c.kind, c.data)
+}
+
+func (c stringCommand) display(v *VT100) error {
+	if c.kind == ']' {
+		code, args := c.data, ""
+		if i := strings.IndexByte(code, ';'); i >= 0 {
+			code, args = code[:i], code[i+1:]
+		}
+		if f, ok := oscHandlers[code]; ok {
+			return f(v, args)
+		}
+	}
+	return supportError(fmt.Sprintf("control string %q", c.kind), nil, fmt.Errorf("%s: unsupported control string", c))
 }
 
 type intHandler func(*VT100, []int) error
 
+// rawHandler is a handler that receives its arguments unparsed.
+type rawHandler func(*VT100, string) error
+
+// subparamHandler is a handler whose arguments may carry colon-separated
+// sub-parameters, e.g. "4:3" for a curly underline. Each element of the arg
+// list is one ;-separated parameter, split on ':'.
+type subparamHandler func(*VT100, [][]int) error
+
 var (
 	// intHandlers are handlers for which all arguments are numbers.
 	// This is most of them -- all the ones that we process. Eventually,
@@ -61,7 +244,7 @@ var (
 	// will instead receive []string, and they'll have to choose on their
 	// own how they might be parsed.
 	intHandlers = map[rune]intHandler{
-		's': save,
+		's': saveOrSetMargins,
 		'7': save,
 		'u': unsave,
 		'8': unsave,
@@ -71,13 +254,82 @@ var (
 		'D': relativeMove(0, -1),
 		'G': absoluteMove,
 		'H': home,
-		'J': eraseLines,
-		'K': eraseColumns,
+		'S': scroll(1),
+		'T': scroll(-1),
+		'J': eraseLines(false),
+		'K': eraseColumns(false),
 		'f': home,
+		'h': setModes(true),
+		'l': setModes(false),
+		'r': setTopBottomMargins,
+	}
+
+	// subparamHandlers take precedence over intHandlers.
+	subparamHandlers = map[rune]subparamHandler{
 		'm': updateAttributes,
 	}
+
+	// rawHandlers take precedence over all of the others, and are mostly for
+	// ESC sequences with intermediates. Those are decoded with the
+	// intermediate as the command, e.g. "ESC ( 0" has command '(' and
+	// arguments "0".
+	rawHandlers = map[rune]rawHandler{
+		'(': designateCharset(0),
+		')': designateCharset(1),
+	}
+
+	// privateHandlers handle DEC private sequences, whose arguments are
+	// prefixed with '?'. The handlers receive the arguments without it.
+	privateHandlers = map[rune]intHandler{
+		'h': setPrivateModes(true),
+		'l': setPrivateModes(false),
+		'J': eraseLines(true),
+		'K': eraseColumns(true),
+	}
+
+	// intermediateHandlers handle CSI sequences with an intermediate byte
+	// before the final one. They're keyed by both, e.g. `"q` for DECSCA, and
+	// receive the arguments without the intermediate.
+	intermediateHandlers = map[string]intHandler{
+		`"q`: setProtection,
+	}
+
+	// oscHandlers handle OSC sequences, keyed by the number before the first
+	// ';'. They receive the data after it.
+	oscHandlers = map[string]rawHandler{
+		// iTerm2's inline files and images, which aren't displayed.
+		"1337": ignore,
+	}
 )
 
+// ignore is a handler for commands that are consumed without effect.
+func ignore(*VT100, string) error {
+	return nil
+}
+
+// designateCharset returns a handler that designates the character set
+// named by its argument as G0 or G1.
+func designateCharset(g int) rawHandler {
+	return func(v *VT100, args string) error {
+		var c Charset
+		switch args {
+		case "B":
+			c = CharsetASCII
+		case "0":
+			c = CharsetLineDrawing
+		default:
+			return supportError("charset", nil, fmt.Errorf("unsupported charset %q", args))
+		}
+
+		if g == 0 {
+			v.G0 = c
+		} else {
+			v.G1 = c
+		}
+		return nil
+	}
+}
+
 func save(v *VT100, _ []int) error {
 	v.save()
 	return nil
@@ -88,39 +340,95 @@ func unsave(v *VT100, _ []int) error {
 	return nil
 }
 
+// saveOrSetMargins handles CSI s, which sets the left and right margins
+// (DECSLRM) when they are enabled, and otherwise saves the cursor.
+func saveOrSetMargins(v *VT100, args []int) error {
+	if !v.LeftRightMarginMode {
+		return save(v, args)
+	}
+
+	left, right := param(args, 0, 1), param(args, 1, v.Width)
+	if left >= right || right > v.Width {
+		return fmt.Errorf("invalid margins (%d, %d)", left, right)
+	}
+
+	v.LeftMargin, v.RightMargin = left-1, right-1
+	return home(v, nil)
+}
+
+// setTopBottomMargins handles DECSTBM, which sets the top and bottom margins
+// and homes the cursor.
+func setTopBottomMargins(v *VT100, args []int) error {
+	top, bottom := param(args, 0, 1), param(args, 1, v.Height)
+	if top >= bottom || bottom > v.Height {
+		return fmt.Errorf("invalid margins (%d, %d)", top, bottom)
+	}
+
+	v.TopMargin, v.BottomMargin = top-1, bottom-1
+	return home(v, nil)
+}
+
 // A command to update the attributes of the cursor based on the arg list.
-func updateAttributes(v *VT100, args []int) error {
+func updateAttributes(v *VT100, params [][]int) error {
 	f := &v.Cursor.F
-	if len(args) == 0 {
-		*f = Format{Reset: true}
+	if len(params) == 0 {
+		*f = Format{Reset: true, Protected: f.Protected}
 		return nil
 	}
 
+	// Most attributes don't take sub-parameters, so work with the flattened
+	// list of leading values and only consult params where it matters.
+	args := make([]int, len(params))
+	for i, p := range params {
+		args[i] = p[0]
+	}
+
 	var unsupported []int
 	i := 0
 	for i < len(args) {
 		x := args[i]
+		sub := params[i][1:]
 		i++
 
 		switch x {
 		case 0:
-			*f = Format{Reset: true}
+			*f = Format{Reset: true, Protected: f.Protected}
 		case 1:
 			f.Intensity = Bold
 		case 2:
 			f.Intensity = Faint
 		case 3:
 			f.Italic = true
+		case 23:
+			f.Italic = false
 		case 22:
 			f.Intensity = Normal
 		case 4:
+			style := SingleUnderline
+			if len(sub) > 0 {
+				style = UnderlineStyle(sub[0])
+			}
+			if style > DashedUnderline {
+				unsupported = append(unsupported, x)
+				continue
+			}
+			f.Underline = style != NoUnderline
+			f.UnderlineStyle = style
+		case 21:
 			f.Underline = true
+			f.UnderlineStyle = DoubleUnderline
 		case 24:
 			f.Underline = false
-		case 5, 6:
-			f.Blink = true // We don't distinguish between blink speeds.
+			f.UnderlineStyle = NoUnderline
+		case 5:
+			f.Blink = true
+			f.RapidBlink = false
+		case 6:
+			f.Blink = false
+			f.RapidBlink = true
 		case 25:
 			f.Blink = false
+			f.RapidBlink = false
 		case 7:
 			f.Reverse = true
 		case 27:
@@ -129,6 +437,14 @@ func updateAttributes(v *VT100, args []int) error {
 			f.Conceal = true
 		case 28:
 			f.Conceal = false
+		case 9:
+			f.CrossOut = true
+		case 29:
+			f.CrossOut = false
+		case 53:
+			f.Overline = true
+		case 55:
+			f.Overline = false
 		case 30, 31, 32, 33, 34, 35, 36, 37:
 			f.Fg = termenv.ANSIColor(x - 30)
 		case 39:
@@ -141,105 +457,236 @@ func updateAttributes(v *VT100, args []int) error {
 			f.Bg = nil
 		case 100, 101, 102, 103, 104, 105, 106, 107:
 			f.Bg = termenv.ANSIColor(x - 100 + 8)
-		case 38, 48: // 256-color foreground/background
-			bg := x == 48
-
-			if len(args) < 2 {
-				return fmt.Errorf("malformed 8- or 24-bit flags: %q", args)
-			}
-
-			type_ := args[i]
-			i++
-
+		case 38, 48, 58: // 256-color or 24-bit foreground/background/underline
 			var color termenv.Color
-			switch type_ {
-			case 5: // 256-color
-				if len(args) < 3 {
-					return fmt.Errorf("malformed 8- or 24-bit flags: %q", args)
+			if len(sub) > 0 {
+				// The colon-separated form keeps the whole color in one
+				// parameter, optionally with a color space ID before the
+				// components, e.g. "58:2::255:0:0".
+				if sub[0] == 2 && len(sub) > 4 {
+					sub = append([]int{2}, sub[2:]...)
 				}
-
-				num := args[i]
-				i++
-				switch {
-				case num < 16:
-					color = termenv.ANSIColor(num)
-				default:
-					color = termenv.ANSI256Color(num)
+				c, _, err := parseSGRColor(sub)
+				if err != nil {
+					return err
 				}
-			case 2: // 24-bit
-				if len(args) < 5 {
-					return fmt.Errorf("malformed 8- or 24-bit flags: %q", args)
+				color = c
+			} else {
+				c, n, err := parseSGRColor(args[i:])
+				if err != nil {
+					return err
 				}
-
-				r := args[i]
-				i++
-				g := args[i]
-				i++
-				b := args[i]
-				i++
-
-				color = termenv.RGBColor(fmt.Sprintf("#%02x%02x%02x", r, g, b))
+				color = c
+				i += n
 			}
 
-			if bg {
-				f.Bg = color
-			} else {
+			switch x {
+			case 38:
 				f.Fg = color
+			case 48:
+				f.Bg = color
+			case 58:
+				f.UnderlineColor = color
 			}
+		case 59:
+			f.UnderlineColor = nil
 		default:
 			unsupported = append(unsupported, x)
 		}
 	}
 
 	if unsupported != nil {
-		return supportError(fmt.Errorf("unknown attributes: %v", unsupported))
+		return supportError("SGR attribute", unsupported, fmt.Errorf("unknown attributes: %v", unsupported))
 	}
 	return nil
 }
 
+// parseSGRColor parses the arguments following an extended color attribute
+// (38, 48, or 58), either "5;n" for a 256-color palette index or "2;r;g;b" for
+// a 24-bit color. It returns the color and the number of arguments consumed.
+func parseSGRColor(args []int) (termenv.Color, int, error) {
+	if len(args) < 1 {
+		return nil, 0, fmt.Errorf("malformed 8- or 24-bit flags: %v", args)
+	}
+
+	switch args[0] {
+	case 5: // 256-color
+		if len(args) < 2 {
+			return nil, 0, fmt.Errorf("malformed 8- or 24-bit flags: %v", args)
+		}
+
+		num := args[1]
+		if num < 0 || num > 255 {
+			return nil, 0, fmt.Errorf("palette index out of range: %v", args)
+		}
+		if num < 16 {
+			return termenv.ANSIColor(num), 2, nil
+		}
+		return termenv.ANSI256Color(num), 2, nil
+	case 2: // 24-bit
+		if len(args) < 4 {
+			return nil, 0, fmt.Errorf("malformed 8- or 24-bit flags: %v", args)
+		}
+
+		r, g, b := args[1], args[2], args[3]
+		for _, c := range []int{r, g, b} {
+			if c < 0 || c > 255 {
+				return nil, 0, fmt.Errorf("color component out of range: %v", args)
+			}
+		}
+		return termenv.RGBColor(fmt.Sprintf("#%02x%02x%02x", r, g, b)), 4, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown color type %d: %v", args[0], args)
+	}
+}
+
+// setModes returns a handler that sets (SM) or resets (RM) each of the ANSI
+// modes in its args.
+func setModes(set bool) intHandler {
+	return func(v *VT100, args []int) error {
+		var unsupported []int
+		for _, mode := range args {
+			switch mode {
+			case 4:
+				v.InsertMode = set
+			default:
+				unsupported = append(unsupported, mode)
+			}
+		}
+
+		if unsupported != nil {
+			return supportError("mode", unsupported, fmt.Errorf("unknown modes: %v", unsupported))
+		}
+		return nil
+	}
+}
+
+// setPrivateModes returns a handler that sets (DECSET) or resets (DECRST)
+// each of the DEC private modes in its args.
+func setPrivateModes(set bool) intHandler {
+	return func(v *VT100, args []int) error {
+		var unsupported []int
+		for _, mode := range args {
+			switch mode {
+			case 9, 1000, 1002, 1003:
+				// These are one setting, as in xterm, so resetting any of
+				// them turns mouse tracking off.
+				if set {
+					v.MouseMode = MouseMode(mode)
+				} else {
+					v.MouseMode = MouseNone
+				}
+			case 2:
+				// Resetting DECANM enters VT52 mode. Setting it selects
+				// ANSI mode, which the terminal must already be in to
+				// have understood the sequence.
+				v.VT52Mode = !set
+			case 3:
+				if !v.FixedColumns {
+					v.setColumnMode(set)
+				}
+			case 6:
+				v.OriginMode = set
+				home(v, nil)
+			case 45:
+				v.ReverseWrap = set
+			case 69:
+				v.LeftRightMarginMode = set
+				if !set {
+					v.LeftMargin, v.RightMargin = 0, v.Width-1
+				}
+			case 2026:
+				if v.SynchronizedOutput && !set {
+					v.pendingFrames++
+				}
+				v.SynchronizedOutput = set
+			case 1005, 1006, 1015:
+				if set {
+					v.MouseEncoding = MouseEncoding(mode)
+				} else if v.MouseEncoding == MouseEncoding(mode) {
+					v.MouseEncoding = MouseEncodingDefault
+				}
+			default:
+				unsupported = append(unsupported, mode)
+			}
+		}
+
+		if unsupported != nil {
+			return supportError("private mode", unsupported, fmt.Errorf("unknown private modes: %v", unsupported))
+		}
+		return nil
+	}
+}
+
 func relativeMove(y, x int) func(*VT100, []int) error {
 	return func(v *VT100, args []int) error {
-		c := 1
-		if len(args) >= 1 {
-			c = args[0]
+		c := param(args, 0, 1)
+		ty, tx := v.Cursor.Y+y*c, v.Cursor.X+x*c
+		if x != 0 {
+			tx = v.stopAtMargins(tx)
 		}
-		// home is 1-indexed, because that's what the terminal sends us. We want to
-		// reuse its sanitization scheme, so we'll just modify our args by that amount.
-		return home(v, []int{v.Cursor.Y + y*c + 1, v.Cursor.X + x*c + 1})
+		return moveTo(v, ty, tx)
 	}
 }
 
-func absoluteMove(v *VT100, args []int) error {
-	x := 1
-	if len(args) >= 1 {
-		x = args[0]
+// scroll returns a handler for SU, if dir is 1, or SD, if it's -1.
+func scroll(dir int) intHandler {
+	return func(v *VT100, args []int) error {
+		v.scroll(dir * param(args, 0, 1))
+		return nil
 	}
+}
 
-	// NB: home is 1-indexed, hence the +1.
-	return home(v, []int{v.Cursor.Y + 1, x})
+func absoluteMove(v *VT100, args []int) error {
+	// NB: the args are 1-indexed, hence the -1.
+	return moveTo(v, v.Cursor.Y, v.originX(param(args, 0, 1)-1))
 }
 
-func eraseColumns(v *VT100, args []int) error {
-	d := eraseForward
-	if len(args) > 0 {
-		d = eraseDirection(args[0])
+// eraseColumns returns a handler for EL, or DECSEL if selective is set.
+func eraseColumns(selective bool) intHandler {
+	return func(v *VT100, args []int) error {
+		d := EraseForward
+		if len(args) > 0 {
+			d = EraseDirection(args[0])
+		}
+		if d > EraseAll {
+			return fmt.Errorf("unknown erase direction: %d", d)
+		}
+		v.eraseColumns(d, selective)
+		return nil
 	}
-	if d > eraseAll {
-		return fmt.Errorf("unknown erase direction: %d", d)
+}
+
+// eraseLines returns a handler for ED, or DECSED if selective is set.
+func eraseLines(selective bool) intHandler {
+	return func(v *VT100, args []int) error {
+		d := EraseForward
+		if len(args) > 0 {
+			d = EraseDirection(args[0])
+		}
+		if d > EraseScrollback || (d == EraseScrollback && selective) {
+			return fmt.Errorf("unknown erase direction: %d", d)
+		}
+		v.eraseLines(d, selective)
+		return nil
 	}
-	v.eraseColumns(d)
-	return nil
 }
 
-func eraseLines(v *VT100, args []int) error {
-	d := eraseForward
+// setProtection handles DECSCA, which sets whether the characters written
+// from now on are protected from selective erases.
+func setProtection(v *VT100, args []int) error {
+	p := 0
 	if len(args) > 0 {
-		d = eraseDirection(args[0])
+		p = args[0]
 	}
-	if d > eraseAll {
-		return fmt.Errorf("unknown erase direction: %d", d)
+	switch p {
+	case 0, 2:
+		v.Cursor.F.Protected = false
+	case 1:
+		v.Cursor.F.Protected = true
+	default:
+		return fmt.Errorf("unknown character protection: %d", p)
 	}
-	v.eraseLines(d)
 	return nil
 }
 
@@ -267,27 +714,98 @@ func sanitize(v *VT100, y, x int) (int, int, error) {
 }
 
 func home(v *VT100, args []int) error {
-	var y, x int
-	if len(args) >= 2 {
-		y, x = args[0]-1, args[1]-1 // home args are 1-indexed.
-	}
+	y, x := param(args, 0, 1)-1, param(args, 1, 1)-1 // home args are 1-indexed.
+	v.unwrap()
+	return v.home(v.originY(y), v.originX(x))
+}
+
+// moveTo moves the cursor to the 0-indexed coordinates y, x.
+func moveTo(v *VT100, y, x int) error {
 	y, x, err := sanitize(v, y, x) // Clamp y and x to the bounds of the terminal.
 	v.home(y, x)                   // Try to do something like what the client asked.
 	return err
 }
 
 func (c escapeCommand) display(v *VT100) error {
+	if f, ok := rawHandlers[c.cmd]; ok {
+		return c.wrap(f(v, c.args))
+	}
+
+	if params, i, ok := c.intermediate(); ok {
+		f, ok := intermediateHandlers[i+string(c.cmd)]
+		if !ok {
+			return supportError(fmt.Sprintf("command %q", i+string(c.cmd)), nil, c.err(errors.New("unsupported command")))
+		}
+
+		args, err := escapeCommand{c.cmd, params}.argInts(v.paramLimits())
+		if err != nil {
+			return c.err(fmt.Errorf("while parsing int args: %v", err))
+		}
+
+		return c.wrap(f(v, args))
+	}
+
+	if strings.HasPrefix(c.args, "?") {
+		f, ok := privateHandlers[c.cmd]
+		if !ok {
+			args, _ := escapeCommand{c.cmd, c.args[1:]}.argInts(v.paramLimits())
+			return supportError(fmt.Sprintf("private command %q", c.cmd), args, c.err(errors.New("unsupported private command")))
+		}
+
+		args, err := escapeCommand{c.cmd, c.args[1:]}.argInts(v.paramLimits())
+		if err != nil {
+			return c.err(fmt.Errorf("while parsing int args: %v", err))
+		}
+
+		return c.wrap(f(v, args))
+	}
+
+	if f, ok := subparamHandlers[c.cmd]; ok {
+		params, err := c.argSubparams(v.paramLimits())
+		if err != nil {
+			return c.err(fmt.Errorf("while parsing int args: %v", err))
+		}
+
+		return c.wrap(f(v, params))
+	}
+
 	f, ok := intHandlers[c.cmd]
 	if !ok {
-		return supportError(c.err(errors.New("unsupported command")))
+		args, _ := c.argInts(v.paramLimits())
+		return supportError(fmt.Sprintf("command %q", c.cmd), args, c.err(errors.New("unsupported command")))
 	}
 
-	args, err := c.argInts()
+	args, err := c.argInts(v.paramLimits())
 	if err != nil {
 		return c.err(fmt.Errorf("while parsing int args: %v", err))
 	}
 
-	return f(v, args)
+	return c.wrap(f(v, args))
+}
+
+// intermediate splits the intermediate byte off the end of c's arguments, if
+// there is one. A '"' that closes a quoted argument isn't one.
+func (c escapeCommand) intermediate() (params, intermediate string, ok bool) {
+	n := len(c.args)
+	if n == 0 || c.args[n-1] < 0x20 || c.args[n-1] > 0x2f {
+		return c.args, "", false
+	}
+	if c.args[n-1] == '"' && strings.Count(c.args, `"`)%2 == 0 {
+		return c.args, "", false
+	}
+	return c.args[:n-1], c.args[n-1:], true
+}
+
+// wrap enhances an error from the handler of c with information about c,
+// unless it's an UnsupportedError, which describes itself.
+func (c escapeCommand) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(UnsupportedError); ok {
+		return err
+	}
+	return c.err(err)
 }
 
 // err enhances e with information about the current escape command
@@ -297,25 +815,123 @@ func (c escapeCommand) err(e error) error {
 
 var csArgsRe = regexp.MustCompile("^([^0-9]*)(.*)$")
 
+// paramLimits bounds the numeric parameters of an escapeCommand. Zero or
+// less means there's no limit.
+type paramLimits struct {
+	// count is the most parameters parsed; any more are ignored.
+	count int
+	// value is the largest absolute value of a parameter; larger ones are
+	// clamped to it.
+	value int
+}
+
+func (v *VT100) paramLimits() paramLimits {
+	return paramLimits{v.MaxParams, v.MaxParamValue}
+}
+
+// capacity returns how many of n parameters will be parsed.
+func (lim paramLimits) capacity(n int) int {
+	if lim.count > 0 && n > lim.count {
+		return lim.count
+	}
+	return n
+}
+
+// parse parses a single parameter, clamping it to lim.value. An empty
+// parameter is 0, which handlers take to mean their default; see param.
+// Parameters can't be negative.
+func (lim paramLimits) parse(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	x, err := strconv.ParseInt(s, 10, 0)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); !ok || ne.Err != strconv.ErrRange || lim.value <= 0 {
+			return 0, err
+		}
+	}
+	if x < 0 {
+		return 0, fmt.Errorf("negative parameter %q", s)
+	}
+	if lim.value > 0 && x > int64(lim.value) {
+		x = int64(lim.value)
+	}
+	return int(x), nil
+}
+
+// param returns args[i], or def if it was omitted or is 0. Either way, the
+// parameter was left to its default.
+func param(args []int, i, def int) int {
+	if i >= len(args) || args[i] == 0 {
+		return def
+	}
+	return args[i]
+}
+
 // argInts parses c.args as a slice of at least arity ints. If the number
 // of ; separated arguments is less than arity, the remaining elements of
 // the result will be zero. errors only on integer parsing failure.
-func (c escapeCommand) argInts() ([]int, error) {
+func (c escapeCommand) argInts(lim paramLimits) ([]int, error) {
 	if len(c.args) == 0 {
 		return make([]int, 0), nil
 	}
-	args := strings.Split(c.args, ";")
-	out := make([]int, len(args))
-	for i, s := range args {
-		x, err := strconv.ParseInt(s, 10, 0)
+	out := make([]int, 0, lim.capacity(strings.Count(c.args, ";")+1))
+	for s, more := c.args, true; more && len(out) < cap(out); {
+		var arg string
+		arg, s, more = cut(s, ';')
+		x, err := lim.parse(arg)
 		if err != nil {
 			return nil, err
 		}
-		out[i] = int(x)
+		out = append(out, x)
+	}
+	return out, nil
+}
+
+// argSubparams is like argInts, but additionally splits each argument into
+// its :-separated sub-parameters. Every element of the result has at least
+// one value.
+func (c escapeCommand) argSubparams(lim paramLimits) ([][]int, error) {
+	if len(c.args) == 0 {
+		return make([][]int, 0), nil
+	}
+	// All of the values share one backing array.
+	n := lim.capacity(strings.Count(c.args, ";") + 1)
+	values := make([]int, 0, lim.capacity(n+strings.Count(c.args, ":")))
+	out := make([][]int, 0, n)
+	for s, more := c.args, true; more && len(out) < cap(out); {
+		var arg string
+		arg, s, more = cut(s, ';')
+		start := len(values)
+		for j, subMore := 0, true; subMore && len(values) < cap(values); j++ {
+			var sub string
+			sub, arg, subMore = cut(arg, ':')
+			if sub == "" && j > 0 {
+				// Sub-parameters may be omitted, e.g. the color space in
+				// "38:2::255:0:0".
+				values = append(values, 0)
+				continue
+			}
+			x, err := lim.parse(sub)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, x)
+		}
+		out = append(out, values[start:len(values):len(values)])
 	}
 	return out, nil
 }
 
+// cut slices s around the first instance of sep, returning the text before
+// and after it. If sep doesn't appear in s, cut returns s, "", false.
+func cut(s string, sep byte) (before, after string, found bool) {
+	if i := strings.IndexByte(s, sep); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
 type controlCommand rune
 
 const (
@@ -325,30 +941,67 @@ const (
 	_verticalTab   controlCommand = '\v'
 	_formfeed      controlCommand = '\f'
 	carriageReturn controlCommand = '\r'
+	shiftOut       controlCommand = '\x0e'
+	shiftIn        controlCommand = '\x0f'
 )
 
 const tabWidth = 4
 
+// controlNames are the abbreviations of the C0 control characters.
+var controlNames = [...]string{
+	"NUL", "SOH", "STX", "ETX", "EOT", "ENQ", "ACK", "BEL",
+	"BS", "HT", "LF", "VT", "FF", "CR", "SO", "SI",
+	"DLE", "DC1", "DC2", "DC3", "DC4", "NAK", "SYN", "ETB",
+	"CAN", "EM", "SUB", "ESC", "FS", "GS", "RS", "US",
+}
+
+func (c controlCommand) String() string {
+	if c >= 0 && int(c) < len(controlNames) {
+		return "CTRL(" + controlNames[c] + ")"
+	}
+	return fmt.Sprintf("CTRL(%U)", rune(c))
+}
+
+func (c controlCommand) Encode(w io.Writer) error {
+	_, err := io.WriteString(w, string(rune(c)))
+	return err
+}
+
+func (c controlCommand) GoString() string {
+	return fmt.Sprintf("controlCommand(%q)", rune(c))
+}
+
 func (c controlCommand) display(v *VT100) error {
 	switch c {
 	case backspace:
 		v.backspace()
 	case linefeed:
+		v.unwrap()
+		if v.partialRegion() {
+			v.lineFeedInRegion()
+			break
+		}
 		// scroll *before* advancing so a trailing linebreak doesn't waste a line
 		v.scrollOrResizeYIfNeeded()
 		v.Cursor.Y++
 		v.Cursor.X = 0
 	case horizontalTab:
+		v.regionWrap = false
 		target := ((v.Cursor.X / tabWidth) + 1) * tabWidth
-		if target >= v.Width {
-			target = v.Width - 1
+		if target >= v.widthLimit() {
+			target = v.widthLimit() - 1
 		}
 		for x := v.Cursor.X; x < target; x++ {
 			v.clear(v.Cursor.Y, x)
 		}
 		v.Cursor.X = target
 	case carriageReturn:
+		v.unwrap()
 		v.Cursor.X = 0
+	case shiftOut:
+		v.ShiftOut = true
+	case shiftIn:
+		v.ShiftOut = false
 	}
 	return nil
 }
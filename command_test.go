@@ -1,9 +1,16 @@
 package vt100_test
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"math/rand"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/muesli/termenv"
 	"github.com/stretchr/testify/assert"
@@ -95,6 +102,40 @@ func TestCursorDirections(t *testing.T) {
 	}
 }
 
+func TestEmptyParams(t *testing.T) {
+	for _, tc := range []struct {
+		seq  string
+		y, x int
+	}{
+		{"[;5H", 0, 4},
+		{"[3;H", 2, 0},
+		{"[;H", 0, 0},
+		{"[3H", 2, 0},
+		{"[0;0H", 0, 0},
+		{"[3;5H" + esc("[A"), 1, 4},
+		{"[3;5H" + esc("[0A"), 1, 4},
+		{"[3;5H" + esc("[;A"), 1, 4},
+		{"[3;5H" + esc("[2;A"), 0, 4},
+	} {
+		v := NewVT100(5, 10)
+		v.Cursor.Y, v.Cursor.X = 4, 9
+		v.Write([]byte(esc(tc.seq)))
+		assert.Equal(t, tc.y, v.Cursor.Y, "after %q", tc.seq)
+		assert.Equal(t, tc.x, v.Cursor.X, "after %q", tc.seq)
+	}
+
+	v := NewVT100(1, 10)
+	// An empty parameter is a reset, wherever it is.
+	assert.Nil(t, v.Process(cmd(esc("[3m"))))
+	assert.Nil(t, v.Process(cmd(esc("[;1m"))))
+	assert.Equal(t, Format{Reset: true, Intensity: Bold}, v.Cursor.F)
+	assert.Nil(t, v.Process(cmd(esc("[3;m"))))
+	assert.Equal(t, Format{Reset: true}, v.Cursor.F)
+	assert.Nil(t, v.Process(cmd(esc("[3m"))))
+	assert.Nil(t, v.Process(cmd(esc("[;m"))))
+	assert.Equal(t, Format{Reset: true}, v.Cursor.F)
+}
+
 func TestErase(t *testing.T) {
 	c := Format{Fg: termenv.ANSIYellow, Intensity: Bold}
 	var d Format
@@ -132,6 +173,11 @@ func TestErase(t *testing.T) {
 			{d, d, d, d},
 			{d, d, d, d},
 		})},
+		{cmd(esc("[3J")), vttest.FromLinesAndFormats("    \n    \n    ", [][]Format{
+			{d, d, d, d},
+			{d, d, d, d},
+			{d, d, d, d},
+		})},
 	} {
 		v := vttest.FromLinesAndFormats(
 			"abcd\nefgh\nijkl", [][]Format{
@@ -151,6 +197,24 @@ func TestErase(t *testing.T) {
 	}
 }
 
+func TestEraseScrollback(t *testing.T) {
+	v, err := New(WithSize(2, 3), WithScrollback(10))
+	assert.Nil(t, err)
+	v.Write([]byte("a\r\nb\r\nc\r\nd"))
+	assert.Equal(t, 2, v.ScrollbackLen())
+	assert.Equal(t, 2, v.LinesScrolled())
+
+	assert.Nil(t, v.Process(cmd(esc("[3J"))))
+	assert.Equal(t, 0, v.ScrollbackLen())
+	assert.Equal(t, 0, v.LinesScrolled())
+	assert.Equal(t, splitLines("   \n   "), v.Content)
+	assert.Equal(t, 0, v.UsedHeight())
+
+	assert.NotNil(t, v.Process(cmd(esc("[?3J"))))
+	assert.NotNil(t, v.Process(cmd(esc("[3K"))))
+	assert.NotNil(t, v.Process(cmd(esc("[4J"))))
+}
+
 var (
 	bs = "\u0008" // Use strings to contain these runes so they can be concatenated easily.
 	lf = "\u000a"
@@ -173,11 +237,25 @@ func TestBackspace(t *testing.T) {
 	assert.Nil(t, v.Process(backspace))
 	assert.Equal(t, 0, v.Cursor.X)
 
+	// It only wraps back to the row above with reverse wrap.
 	v = vttest.FromLines("..\n..")
 	v.Cursor.Y, v.Cursor.X = 1, 0
 	assert.Nil(t, v.Process(backspace))
+	assert.Equal(t, 1, v.Cursor.Y)
+	assert.Equal(t, 0, v.Cursor.X)
+
+	assert.Nil(t, v.Process(cmd(esc("[?45h"))))
+	assert.True(t, v.ReverseWrap)
+	assert.Nil(t, v.Process(backspace))
 	assert.Equal(t, 0, v.Cursor.Y)
 	assert.Equal(t, 1, v.Cursor.X)
+	assert.Nil(t, v.Process(cmd(esc("[?45l"))))
+	assert.False(t, v.ReverseWrap)
+
+	// Or when the cursor has only just wrapped there.
+	v = NewVT100(2, 2)
+	v.Write([]byte("ab" + bs + "c"))
+	assert.Equal(t, splitLines("ac\n  "), v.Content)
 }
 
 func TestLineFeed(t *testing.T) {
@@ -231,10 +309,34 @@ func TestAttributes(t *testing.T) {
 	assert.Equal(t, io.EOF, err)
 	assert.Equal(t, []rune("abcd"), v.Content[0])
 	assert.Equal(t, []Format{
-		{Intensity: Faint}, {Blink: true, Fg: termenv.ANSIRed}, {Reset: true}, {Reset: true, Underline: true, Bg: termenv.ANSICyan},
+		{Intensity: Faint}, {Blink: true, Fg: termenv.ANSIRed}, {Reset: true}, {Reset: true, Underline: true, UnderlineStyle: SingleUnderline, Bg: termenv.ANSICyan},
 	}, v.Format[0])
 }
 
+func TestAttributesOff(t *testing.T) {
+	all := Format{
+		Intensity: Bold, Italic: true, Underline: true, UnderlineStyle: SingleUnderline,
+		Blink: true, Reverse: true, Conceal: true, CrossOut: true,
+	}
+	for code, off := range map[string]func(*Format){
+		"22": func(f *Format) { f.Intensity = Normal },
+		"23": func(f *Format) { f.Italic = false },
+		"24": func(f *Format) { f.Underline, f.UnderlineStyle = false, NoUnderline },
+		"25": func(f *Format) { f.Blink = false },
+		"27": func(f *Format) { f.Reverse = false },
+		"28": func(f *Format) { f.Conceal = false },
+		"29": func(f *Format) { f.CrossOut = false },
+	} {
+		v := NewVT100(1, 2)
+		v.Write([]byte(esc("[1;3;4;5;7;8;9ma") + esc("["+code+"mb")))
+		assert.Equal(t, all, v.Format[0][0], "before %s", code)
+
+		want := all
+		off(&want)
+		assert.Equal(t, want, v.Format[0][1], "after %s", code)
+	}
+}
+
 func TestEmptyReset(t *testing.T) {
 	v := vttest.FromLines("....")
 	s := strings.NewReader(
@@ -247,7 +349,7 @@ func TestEmptyReset(t *testing.T) {
 	assert.Equal(t, io.EOF, err)
 	assert.Equal(t, []rune("abcd"), v.Content[0])
 	assert.Equal(t, []Format{
-		{Intensity: Faint}, {Blink: true, Fg: termenv.ANSIRed}, {Reset: true}, {Reset: true, Underline: true, Bg: termenv.ANSICyan},
+		{Intensity: Faint}, {Blink: true, Fg: termenv.ANSIRed}, {Reset: true}, {Reset: true, Underline: true, UnderlineStyle: SingleUnderline, Bg: termenv.ANSICyan},
 	}, v.Format[0])
 }
 
@@ -322,6 +424,121 @@ func TestAutoResizeX(t *testing.T) {
 	}, v.Format[0])
 }
 
+func TestAutoResizeXPastEdge(t *testing.T) {
+	// After the last column, the cursor waits past the edge for the
+	// terminal to grow.
+	v := NewVT100(2, 3)
+	v.AutoResizeX = true
+	v.Write([]byte("abc"))
+	assert.Equal(t, Cursor{Y: 0, X: 3}, v.Cursor)
+
+	// Erasing forward from there leaves the row alone.
+	v.Write([]byte(esc("[K")))
+	assert.Equal(t, "abc", string(v.Content[0]))
+	v.Write([]byte(esc("[1K")))
+	assert.Equal(t, "   ", string(v.Content[0]))
+	assert.Equal(t, 3, v.Width)
+
+	v.Write([]byte("d"))
+	assert.Equal(t, "   d", string(v.Content[0]))
+	assert.Equal(t, 4, v.Width)
+
+	// As does a resize, or a tab.
+	v.Resize(3, 4)
+	assert.Equal(t, 4, v.Cursor.X)
+	v.Write([]byte("\te"))
+	assert.Equal(t, "   d    e", string(v.Content[0]))
+
+	// CUP back onto the screen and past it.
+	v.Write([]byte(esc("[2;9HK") + esc("[2;12H") + esc("[K") + "L"))
+	assert.Equal(t, "        K  L", string(v.Content[1]))
+	assert.Equal(t, 12, v.Width)
+	v.Write([]byte(esc("[2;1H") + esc("[K")))
+	assert.Equal(t, strings.Repeat(" ", 12), string(v.Content[1]))
+}
+
+func TestMaxWidth(t *testing.T) {
+	v := NewVT100(1, 10)
+	v.AutoResizeX = true
+	v.AutoResizeY = true
+	v.MaxWidth = 4096
+
+	// One enormous line grows the terminal up to MaxWidth, then wraps.
+	line := strings.Repeat("0123456789abcdef", 1<<16)
+	v.Write([]byte(line))
+	assert.Equal(t, 4096, v.Width)
+	assert.Equal(t, len(line)/4096, v.Height)
+	for y, row := range v.Content {
+		assert.Equal(t, 4096, cap(row))
+		if !assert.Equal(t, line[y*4096:(y+1)*4096], string(row)) {
+			break
+		}
+	}
+
+	// The cursor can't go past it either.
+	assert.NotNil(t, v.Process(cmd(esc("[1;5000H"))))
+	assert.Equal(t, 4095, v.Cursor.X)
+	v.Write([]byte("\t"))
+	assert.Equal(t, 4095, v.Cursor.X)
+
+	// Or the rest of the line is dropped.
+	v = NewVT100(2, 2)
+	v.AutoResizeX = true
+	v.MaxWidth = 4
+	v.TruncateAtMaxWidth = true
+	v.Write([]byte("abcdef\r\ngh"))
+	assert.Equal(t, splitLines("abcd\ngh  "), v.Content)
+}
+
+func TestMaxHeight(t *testing.T) {
+	v, err := New(WithSize(1, 3), WithAutoResize(false, true), WithMaxSize(10, 0), WithScrollback(100))
+	assert.Nil(t, err)
+
+	// The terminal grows up to MaxHeight, then scrolls.
+	for i := 0; i < 100; i++ {
+		v.Write([]byte(fmt.Sprintf("\r\n%d", i)))
+	}
+	assert.Equal(t, 10, v.Height)
+	assert.Equal(t, "99 ", string(v.Content[9]))
+	assert.Equal(t, "90 ", string(v.Content[0]))
+	assert.Equal(t, 91, v.ScrollbackLen())
+
+	// CUP stops at it too.
+	assert.NotNil(t, v.Process(cmd(esc("[50;1H"))))
+	assert.Equal(t, 9, v.Cursor.Y)
+
+	// With OverflowClamp, the last line is overwritten instead.
+	v, err = New(WithSize(1, 3), WithAutoResize(false, true), WithMaxSize(2, 0), WithOverflow(OverflowClamp))
+	assert.Nil(t, err)
+	v.Write([]byte("a\r\nb\r\nc"))
+	assert.Equal(t, splitLines("a  \nc  "), v.Content)
+}
+
+func TestMaxHeightAfterNew(t *testing.T) {
+	var spilled bytes.Buffer
+	v := NewVT100(1, 4)
+	v.AutoResizeY = true
+	v.ScrollbackWriter = &spilled
+	v.Write([]byte("0\r\n1\r\n2"))
+	assert.Equal(t, 3, v.Height)
+
+	// A cap set later takes effect the next time the terminal would grow,
+	// and the rows that scroll off can be spilled elsewhere.
+	v.MaxHeight = 4
+	for i := 3; i < 20; i++ {
+		v.Write([]byte(fmt.Sprintf("\r\n%d", i)))
+	}
+	assert.Equal(t, 4, v.Height)
+	assert.Equal(t, []string{"16", "17", "18", "19"}, v.TrimmedLines())
+	assert.Equal(t, "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n", spilled.String())
+
+	// A cap below the current height just stops it growing.
+	v.MaxHeight = 2
+	v.Write([]byte("\r\n20"))
+	assert.Equal(t, 4, v.Height)
+	assert.Equal(t, []string{"17", "18", "19", "20"}, v.TrimmedLines())
+}
+
 func TestAutoResizeY(t *testing.T) {
 	v := NewVT100(1, 1)
 	v.AutoResizeY = true
@@ -364,3 +581,792 @@ func TestAutoResizeXY(t *testing.T) {
 	assert.Equal(t, "12345", string(v.Content[1]))
 	assert.Equal(t, []Format{{}, {}, {}, {}, {}}, v.Format[1])
 }
+
+func TestOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		overflow OverflowBehavior
+		want     []string
+	}{
+		{OverflowScroll, []string{"c ", "d "}},
+		{OverflowResizeY, []string{"a ", "b ", "c ", "d "}},
+		{OverflowClamp, []string{"a ", "d "}},
+	} {
+		v := NewVT100(2, 2)
+		v.Overflow = tc.overflow
+		v.Write([]byte("a\nb\nc\nd"))
+
+		var got []string
+		for _, line := range v.Content {
+			got = append(got, string(line))
+		}
+		assert.Equal(t, tc.want, got, "with overflow %d", tc.overflow)
+		assert.Equal(t, len(tc.want)-1, v.Cursor.Y)
+	}
+}
+
+func TestItalicCrossOutOverline(t *testing.T) {
+	v := vttest.FromLines("......")
+	s := strings.NewReader(
+		esc("[3ma") + esc("[9mb") + esc("[53mc") + esc("[23md") + esc("[29me") + esc("[55mf"))
+	cmd, err := Decode(s)
+	for err == nil {
+		assert.Nil(t, v.Process(cmd))
+		cmd, err = Decode(s)
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []rune("abcdef"), v.Content[0])
+	assert.Equal(t, []Format{
+		{Italic: true},
+		{Italic: true, CrossOut: true},
+		{Italic: true, CrossOut: true, Overline: true},
+		{CrossOut: true, Overline: true},
+		{Overline: true},
+		{},
+	}, v.Format[0])
+}
+
+func TestDefaultColors(t *testing.T) {
+	v := vttest.FromLines("...")
+	s := strings.NewReader(
+		esc("[1;31;44ma") + esc("[39mb") + esc("[49mc"))
+	cmd, err := Decode(s)
+	for err == nil {
+		assert.Nil(t, v.Process(cmd))
+		cmd, err = Decode(s)
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []rune("abc"), v.Content[0])
+	assert.Equal(t, []Format{
+		{Intensity: Bold, Fg: termenv.ANSIRed, Bg: termenv.ANSIBlue},
+		{Intensity: Bold, Bg: termenv.ANSIBlue},
+		{Intensity: Bold},
+	}, v.Format[0])
+}
+
+func TestUnderlineStyles(t *testing.T) {
+	v := vttest.FromLines(".....")
+	s := strings.NewReader(
+		esc("[4ma") + esc("[4:3mb") + esc("[21mc") + esc("[4:0md") + esc("[4:2;24me"))
+	cmd, err := Decode(s)
+	for err == nil {
+		assert.Nil(t, v.Process(cmd))
+		cmd, err = Decode(s)
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []rune("abcde"), v.Content[0])
+	assert.Equal(t, []Format{
+		{Underline: true, UnderlineStyle: SingleUnderline},
+		{Underline: true, UnderlineStyle: CurlyUnderline},
+		{Underline: true, UnderlineStyle: DoubleUnderline},
+		{},
+		{},
+	}, v.Format[0])
+}
+
+func TestBlink(t *testing.T) {
+	v := vttest.FromLines("...")
+	s := strings.NewReader(esc("[5ma") + esc("[6mb") + esc("[25mc"))
+	cmd, err := Decode(s)
+	for err == nil {
+		assert.Nil(t, v.Process(cmd))
+		cmd, err = Decode(s)
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []rune("abc"), v.Content[0])
+	assert.Equal(t, []Format{
+		{Blink: true}, {RapidBlink: true}, {},
+	}, v.Format[0])
+}
+
+func TestUnderlineColor(t *testing.T) {
+	v := vttest.FromLines("...")
+	s := strings.NewReader(
+		esc("[4;58;5;196;1ma") + esc("[58;2;255;128;0;3mb") + esc("[59mc"))
+	cmd, err := Decode(s)
+	for err == nil {
+		assert.Nil(t, v.Process(cmd))
+		cmd, err = Decode(s)
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []rune("abc"), v.Content[0])
+	assert.Equal(t, []Format{
+		{Underline: true, UnderlineStyle: SingleUnderline, UnderlineColor: termenv.ANSI256Color(196), Intensity: Bold},
+		{Underline: true, UnderlineStyle: SingleUnderline, UnderlineColor: termenv.RGBColor("#ff8000"), Intensity: Bold, Italic: true},
+		{Underline: true, UnderlineStyle: SingleUnderline, Intensity: Bold, Italic: true},
+	}, v.Format[0])
+}
+
+func TestColonColors(t *testing.T) {
+	v := vttest.FromLines("...")
+	s := strings.NewReader(
+		esc("[58:2::255:128:0ma") + esc("[38:5:196;48:2:0:0:255mb") + esc("[58:5:3;1mc"))
+	cmd, err := Decode(s)
+	for err == nil {
+		assert.Nil(t, v.Process(cmd))
+		cmd, err = Decode(s)
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, []rune("abc"), v.Content[0])
+	assert.Equal(t, []Format{
+		{UnderlineColor: termenv.RGBColor("#ff8000")},
+		{UnderlineColor: termenv.RGBColor("#ff8000"), Fg: termenv.ANSI256Color(196), Bg: termenv.RGBColor("#0000ff")},
+		{UnderlineColor: termenv.ANSIYellow, Fg: termenv.ANSI256Color(196), Bg: termenv.RGBColor("#0000ff"), Intensity: Bold},
+	}, v.Format[0])
+}
+
+func TestInsertMode(t *testing.T) {
+	v := NewVT100(1, 5)
+	v.Write([]byte("ABCDE" + esc("[1;3H") + esc("[4h")))
+	assert.True(t, v.InsertMode)
+
+	v.Write([]byte(esc("[31m") + "X"))
+	assert.Equal(t, "ABXCD", string(v.Content[0]))
+	assert.Equal(t, Format{Fg: termenv.ANSIRed}, v.Format[0][2])
+	assert.Equal(t, Format{}, v.Format[0][3])
+	assert.Equal(t, 3, v.Cursor.X)
+
+	v.Write([]byte(esc("[4l") + "Y"))
+	assert.False(t, v.InsertMode)
+	assert.Equal(t, "ABXYD", string(v.Content[0]))
+
+	assert.NotNil(t, v.Process(cmd(esc("[20h"))))
+
+	// With left and right margins, text past the right one is left alone.
+	v = NewVT100(1, 6)
+	v.Write([]byte("ABCDEF" + esc("[?69h") + esc("[2;4s") + esc("[1;2H") + esc("[4h") + "X"))
+	assert.Equal(t, "AXBCEF", string(v.Content[0]))
+}
+
+func TestVT52Mode(t *testing.T) {
+	v := NewVT100(4, 7)
+	v.Write([]byte("abcdef\r\nghijkl\r\nmnopqr" + esc("[?2l")))
+	assert.True(t, v.VT52Mode)
+
+	// ESC Y addresses the cursor directly, with the row and column offset
+	// by 32. It may be split across writes.
+	v.Write([]byte(esc("Y") + "!"))
+	v.Write([]byte("#"))
+	assert.Equal(t, Cursor{Y: 1, X: 3}, v.Cursor)
+
+	v.Write([]byte(esc("A")))
+	assert.Equal(t, Cursor{Y: 0, X: 3}, v.Cursor)
+	v.Write([]byte(esc("B") + esc("B")))
+	assert.Equal(t, Cursor{Y: 2, X: 3}, v.Cursor)
+	v.Write([]byte(esc("C")))
+	assert.Equal(t, Cursor{Y: 2, X: 4}, v.Cursor)
+	v.Write([]byte(esc("D") + esc("D")))
+	assert.Equal(t, Cursor{Y: 2, X: 2}, v.Cursor)
+
+	v.Write([]byte(esc("K")))
+	assert.Equal(t, "mn     ", string(v.Content[2]))
+	v.Write([]byte(esc("H")))
+	assert.Equal(t, Cursor{}, v.Cursor)
+	v.Write([]byte("X" + esc("Y") + "! " + esc("J")))
+	assert.Equal(t, splitLines("Xbcdef \n       \n       \n       "), v.Content)
+	v.Write([]byte(esc("Y") + "  "))
+
+	// CSI sequences aren't understood in VT52 mode: ESC [ is a VT52
+	// command of its own, which isn't supported, and the rest is text.
+	v.Write([]byte(esc("[2C")))
+	assert.Equal(t, "2Ccdef ", string(v.Content[0]))
+
+	v.Write([]byte(esc("<")))
+	assert.False(t, v.VT52Mode)
+	v.Write([]byte(esc("[4;1H") + "yz"))
+	assert.Equal(t, "yz     ", string(v.Content[3]))
+	assert.Equal(t, Cursor{Y: 3, X: 2}, v.Cursor)
+}
+
+func TestVT52ModeEntryPoints(t *testing.T) {
+	const input = "ab" + "\x1b[?2l" + "\x1bY  Z" + "\x1b<" + "\x1b[2;1Hc"
+	for name, process := range map[string]func(*VT100) error{
+		"Write": func(v *VT100) error {
+			_, err := v.Write([]byte(input))
+			return err
+		},
+		"ProcessString": func(v *VT100) error {
+			return v.ProcessString(input)
+		},
+		"ProcessReader": func(v *VT100) error {
+			return ProcessReader(v, strings.NewReader(input))
+		},
+		"Decode": func(v *VT100) error {
+			s := strings.NewReader(input)
+			for {
+				c, err := v.Decode(s)
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if err := v.Process(c); err != nil {
+					return err
+				}
+			}
+		},
+	} {
+		v := NewVT100(2, 3)
+		assert.Nil(t, process(v), name)
+		assert.Equal(t, splitLines("Zb \nc  "), v.Content, name)
+		assert.False(t, v.VT52Mode, name)
+	}
+}
+
+func TestMouseModes(t *testing.T) {
+	v := NewVT100(1, 4)
+	v.Write([]byte("ab" + esc("[?1000h") + esc("[?1002;1006h") + "cd"))
+	assert.Equal(t, "abcd", string(v.Content[0]))
+	assert.Equal(t, MouseButtonEvent, v.MouseMode)
+	assert.Equal(t, MouseEncodingSGR, v.MouseEncoding)
+
+	assert.Nil(t, v.Process(cmd(esc("[?1003h"))))
+	assert.Equal(t, MouseAnyEvent, v.MouseMode)
+
+	assert.Nil(t, v.Process(cmd(esc("[?1003;1006l"))))
+	assert.Equal(t, MouseNone, v.MouseMode)
+	assert.Equal(t, MouseEncodingDefault, v.MouseEncoding)
+
+	assert.Nil(t, v.Process(cmd(esc("[?1015h"))))
+	assert.Equal(t, MouseEncodingURXVT, v.MouseEncoding)
+
+	// Resetting any of the tracking modes turns off whichever is on.
+	assert.Nil(t, v.Process(cmd(esc("[?1002h"))))
+	assert.Nil(t, v.Process(cmd(esc("[?1000l"))))
+	assert.Equal(t, MouseNone, v.MouseMode)
+	assert.Nil(t, v.Process(cmd(esc("[?1003h"))))
+	assert.Nil(t, v.Process(cmd(esc("[?9l"))))
+	assert.Equal(t, MouseNone, v.MouseMode)
+
+	assert.Equal(t, "abcd", string(v.Content[0]))
+}
+
+func TestSynchronizedOutput(t *testing.T) {
+	v := NewVT100(1, 4)
+
+	var frames []string
+	v.OnFrame = func() {
+		// The terminal is unlocked by the time we're called.
+		frames = append(frames, string(v.Snapshot().Content[0]))
+	}
+
+	v.Write([]byte(esc("[?2026h") + "ab"))
+	assert.True(t, v.SynchronizedOutput)
+	assert.Empty(t, frames)
+
+	v.Write([]byte(esc("[?2026l")))
+	assert.False(t, v.SynchronizedOutput)
+	assert.Equal(t, []string{"ab  "}, frames)
+
+	// Callbacks run after the whole write is processed.
+	v.Write([]byte(esc("[?2026h") + "c" + esc("[?2026l") + esc("[?2026h") + "d" + esc("[?2026l")))
+	assert.Equal(t, []string{"ab  ", "abcd", "abcd"}, frames)
+
+	// Ending synchronized output that was never started isn't a frame.
+	assert.Nil(t, v.Process(cmd(esc("[?2026l"))))
+	assert.Len(t, frames, 3)
+}
+
+func TestLeftRightMargins(t *testing.T) {
+	v := NewVT100(3, 10)
+
+	// Without DECLRMM, CSI s saves the cursor.
+	assert.Nil(t, v.Process(cmd(esc("[3;6s"))))
+	assert.Equal(t, 0, v.LeftMargin)
+	assert.Equal(t, 9, v.RightMargin)
+
+	assert.Nil(t, v.Process(cmd(esc("[?69h"))))
+	assert.Nil(t, v.Process(cmd(esc("[2;5s"))))
+	assert.Equal(t, 1, v.LeftMargin)
+	assert.Equal(t, 4, v.RightMargin)
+	assert.Equal(t, Cursor{}, v.Cursor)
+
+	// Forward motion from within the margins stops at the right margin.
+	assert.Nil(t, v.Process(cmd(esc("[2G"))))
+	assert.Nil(t, v.Process(cmd(esc("[10C"))))
+	assert.Equal(t, 4, v.Cursor.X)
+
+	// And backward motion stops at the left margin.
+	assert.Nil(t, v.Process(cmd(esc("[10D"))))
+	assert.Equal(t, 1, v.Cursor.X)
+
+	// Past the right margin, forward motion isn't limited by it.
+	assert.Nil(t, v.Process(cmd(esc("[7G"))))
+	assert.Nil(t, v.Process(cmd(esc("[2C"))))
+	assert.Equal(t, 8, v.Cursor.X)
+
+	// In origin mode, columns are relative to the left margin.
+	assert.Nil(t, v.Process(cmd(esc("[?6h"))))
+	assert.Equal(t, 1, v.Cursor.X)
+	assert.Nil(t, v.Process(cmd(esc("[1;3H"))))
+	assert.Equal(t, 3, v.Cursor.X)
+	assert.Nil(t, v.Process(cmd(esc("[1;9H"))))
+	assert.Equal(t, 4, v.Cursor.X)
+
+	assert.NotNil(t, v.Process(cmd(esc("[5;5s"))))
+
+	assert.Nil(t, v.Process(cmd(esc("[?69l"))))
+	assert.Equal(t, 0, v.LeftMargin)
+	assert.Equal(t, 9, v.RightMargin)
+}
+
+func TestTopBottomMargins(t *testing.T) {
+	v := NewVT100(6, 4)
+	v.Write([]byte(esc("[5;3H")))
+
+	// DECSTBM homes the cursor.
+	assert.Nil(t, v.Process(cmd(esc("[2;4r"))))
+	assert.Equal(t, 1, v.TopMargin)
+	assert.Equal(t, 3, v.BottomMargin)
+	assert.Equal(t, 0, v.Cursor.Y)
+	assert.Equal(t, 0, v.Cursor.X)
+
+	// In origin mode, home is the top margin.
+	assert.Nil(t, v.Process(cmd(esc("[?6h"))))
+	v.Write([]byte(esc("[5;3H")))
+	assert.Nil(t, v.Process(cmd(esc("[2;4r"))))
+	assert.Equal(t, 1, v.Cursor.Y)
+	assert.Equal(t, 0, v.Cursor.X)
+
+	// And rows are relative to it, up to the bottom margin.
+	assert.Nil(t, v.Process(cmd(esc("[2;2H"))))
+	assert.Equal(t, 2, v.Cursor.Y)
+	assert.Nil(t, v.Process(cmd(esc("[6;1H"))))
+	assert.Equal(t, 3, v.Cursor.Y)
+
+	assert.NotNil(t, v.Process(cmd(esc("[4;2r"))))
+	assert.NotNil(t, v.Process(cmd(esc("[1;7r"))))
+	assert.Equal(t, 1, v.TopMargin)
+	assert.Equal(t, 3, v.BottomMargin)
+
+	// Without arguments, the margins are the whole screen.
+	assert.Nil(t, v.Process(cmd(esc("[r"))))
+	assert.Equal(t, 0, v.TopMargin)
+	assert.Equal(t, 5, v.BottomMargin)
+	assert.Equal(t, 0, v.Cursor.Y)
+
+	assert.Nil(t, v.Process(cmd(esc("[2;4r"))))
+	v.Resize(8, 4)
+	assert.Equal(t, 0, v.TopMargin)
+	assert.Equal(t, 7, v.BottomMargin)
+}
+
+func TestScrollRegion(t *testing.T) {
+	v, err := New(WithSize(5, 3), WithScrollback(10))
+	assert.Nil(t, err)
+	v.Write([]byte("a\r\nb\r\nc\r\nd\r\ne" + esc("[2;4r")))
+
+	// A linefeed at the bottom margin scrolls the rows between the margins,
+	// and the row scrolled off isn't kept.
+	v.Write([]byte(esc("[4;1H") + "\n"))
+	assert.Equal(t, splitLines("a  \nc  \nd  \n   \ne  "), v.Content)
+	assert.Equal(t, Cursor{Y: 3, X: 0}, v.Cursor)
+	assert.Equal(t, 0, v.ScrollbackLen())
+
+	// As do SD and SU, which leave the cursor where it is.
+	v.Write([]byte(esc("[T")))
+	assert.Equal(t, splitLines("a  \n   \nc  \nd  \ne  "), v.Content)
+	v.Write([]byte(esc("[9S")))
+	assert.Equal(t, splitLines("a  \n   \n   \n   \ne  "), v.Content)
+	assert.Equal(t, Cursor{Y: 3, X: 0}, v.Cursor)
+
+	// Text wraps within the region, but only once there's more of it.
+	v.Write([]byte("xyz"))
+	assert.Equal(t, splitLines("a  \n   \n   \nxyz\ne  "), v.Content)
+	assert.Equal(t, Cursor{Y: 3, X: 2}, v.Cursor)
+	v.Write([]byte("w"))
+	assert.Equal(t, splitLines("a  \n   \nxyz\nw  \ne  "), v.Content)
+	assert.True(t, v.LineWrapped[3])
+
+	// Below the region, a linefeed at the bottom of the screen goes nowhere.
+	v.Write([]byte(esc("[5;2H") + "\nf"))
+	assert.Equal(t, splitLines("a  \n   \nxyz\nw  \nf  "), v.Content)
+
+	// Without margins, the whole screen scrolls again.
+	v.Write([]byte(esc("[r") + esc("[5;1H") + "\ng"))
+	assert.Equal(t, splitLines("   \nxyz\nw  \nf  \ng  "), v.Content)
+	assert.Equal(t, 1, v.ScrollbackLen())
+}
+
+func TestColumnMode(t *testing.T) {
+	v := NewVT100(2, 80)
+	v.Write([]byte("hello\r\nworld"))
+
+	v.Write([]byte(esc("[?3h")))
+	assert.Equal(t, 132, v.Width)
+	assert.Equal(t, 2, v.Height)
+	assert.Equal(t, strings.Repeat(" ", 132), string(v.Content[0]))
+	assert.Equal(t, strings.Repeat(" ", 132), string(v.Content[1]))
+	assert.Equal(t, 0, v.Cursor.Y)
+	assert.Equal(t, 0, v.Cursor.X)
+
+	v.Write([]byte("hi" + esc("[?3l")))
+	assert.Equal(t, 80, v.Width)
+	assert.Equal(t, strings.Repeat(" ", 80), string(v.Content[0]))
+
+	// The host can keep control of the width.
+	v.FixedColumns = true
+	v.Write([]byte("hi" + esc("[?3h")))
+	assert.Equal(t, 80, v.Width)
+	assert.Equal(t, "hi", string(v.Content[0][:2]))
+}
+
+func TestCursorStack(t *testing.T) {
+	v := NewVT100(4, 4)
+	assert.False(t, v.PopCursor())
+
+	v.Write([]byte(esc("[2;3H") + esc("[1m")))
+	v.PushCursor()
+	v.Write([]byte(esc("[3;4H") + esc("[0m") + esc("(0")))
+	v.PushCursor()
+	v.Write([]byte(esc("[4;1H") + esc("(B")))
+
+	assert.True(t, v.PopCursor())
+	assert.Equal(t, 2, v.Cursor.Y)
+	assert.Equal(t, 3, v.Cursor.X)
+	assert.Equal(t, CharsetLineDrawing, v.G0)
+
+	assert.True(t, v.PopCursor())
+	assert.Equal(t, 1, v.Cursor.Y)
+	assert.Equal(t, 2, v.Cursor.X)
+	assert.Equal(t, Bold, v.Cursor.F.Intensity)
+	assert.Equal(t, CharsetASCII, v.G0)
+
+	assert.False(t, v.PopCursor())
+	assert.Equal(t, 1, v.Cursor.Y)
+	assert.Equal(t, 2, v.Cursor.X)
+
+	// DECSC overwrites the top of the stack rather than growing it.
+	v.PushCursor()
+	v.Write([]byte(esc("[1;1H") + esc("7") + esc("[4;4H") + esc("8")))
+	assert.Equal(t, 0, v.Cursor.Y)
+	assert.Equal(t, 0, v.Cursor.X)
+	assert.True(t, v.PopCursor())
+	assert.Equal(t, 0, v.Cursor.Y)
+	assert.Equal(t, 0, v.Cursor.X)
+	assert.False(t, v.PopCursor())
+
+	// DECRC can restore the same state more than once.
+	v.Write([]byte(esc("[2;2H") + esc("7") + esc("[4;4H") + esc("8") + esc("[4;4H") + esc("8")))
+	assert.Equal(t, 1, v.Cursor.Y)
+	assert.Equal(t, 1, v.Cursor.X)
+
+	// A cursor saved somewhere the terminal no longer reaches is restored to
+	// its edges.
+	v.Write([]byte(esc("[4;4H") + esc("7")))
+	v.PushCursor()
+	v.Resize(2, 3)
+	v.Write([]byte(esc("8")))
+	assert.Equal(t, 1, v.Cursor.Y)
+	assert.Equal(t, 2, v.Cursor.X)
+	v.Write([]byte("x"))
+	assert.Equal(t, splitLines("   \n  x"), v.Content)
+	assert.True(t, v.PopCursor())
+	assert.Equal(t, 1, v.Cursor.Y)
+	assert.Equal(t, 2, v.Cursor.X)
+}
+
+func TestCharsets(t *testing.T) {
+	v := NewVT100(1, 12)
+
+	// Designate line drawing as G1, and shift between it and G0.
+	assert.Nil(t, v.Process(cmd(esc(")0"))))
+	assert.Equal(t, CharsetLineDrawing, v.G1)
+	v.Write([]byte("lq\x0eqk\x0fx\x0emj\x0f!"))
+	assert.Equal(t, "lq─┐x└┘!    ", string(v.Content[0]))
+
+	// Designating G0 takes effect immediately.
+	v.Write([]byte("\r" + esc("(0") + "tqu" + esc("(B") + "tqu"))
+	assert.Equal(t, "├─┤tqu┘!    ", string(v.Content[0]))
+	assert.Equal(t, CharsetASCII, v.G0)
+
+	assert.NotNil(t, v.Process(cmd(esc("(%5"))))
+}
+
+func TestSelectiveErase(t *testing.T) {
+	v := NewVT100(2, 8)
+	v.Write([]byte("ab" + esc(`[1"q`) + "cd" + esc(`[0"q`) + "ef\r\n"))
+	v.Write([]byte(esc(`[1"q`) + "gh" + esc("[0m") + "ij" + esc(`[2"q`) + "kl"))
+	assert.True(t, v.Format[0][2].Protected)
+	assert.False(t, v.Format[0][4].Protected)
+	// SGR doesn't reset protection.
+	assert.True(t, v.Format[1][3].Protected)
+
+	// DECSEL erases the unprotected cells of the line.
+	v.Write([]byte(esc("[1;1H") + esc("[?2K")))
+	assert.Equal(t, "  cd    ", string(v.Content[0]))
+
+	// DECSED erases the unprotected cells of the screen.
+	v.Write([]byte(esc("[?J")))
+	assert.Equal(t, "  cd    ", string(v.Content[0]))
+	assert.Equal(t, "ghij    ", string(v.Content[1]))
+
+	// Ordinary erases don't care.
+	v.Write([]byte(esc("[2J")))
+	assert.Equal(t, "        ", string(v.Content[0]))
+	assert.Equal(t, "        ", string(v.Content[1]))
+
+	assert.NotNil(t, v.Process(cmd(esc(`[3"q`))))
+}
+
+func TestParamLimits(t *testing.T) {
+	v := NewVT100(1, 10)
+	assert.Equal(t, DefaultMaxParams, v.MaxParams)
+	assert.Equal(t, DefaultMaxParamValue, v.MaxParamValue)
+
+	// Parameters past the limit are ignored.
+	v.MaxParams = 2
+	v.Write([]byte(esc("[1;3;4ma")))
+	assert.Equal(t, Bold, v.Format[0][0].Intensity)
+	assert.True(t, v.Format[0][0].Italic)
+	assert.Equal(t, NoUnderline, v.Format[0][0].UnderlineStyle)
+
+	// Including sub-parameters.
+	v.Write([]byte(esc("[0m") + esc("[4:3:1;3mb")))
+	assert.Equal(t, CurlyUnderline, v.Format[0][1].UnderlineStyle)
+	assert.False(t, v.Format[0][1].Italic)
+
+	// Large values are clamped.
+	v.MaxParamValue = 3
+	v.Write([]byte(esc("[H") + esc("[9C")))
+	assert.Equal(t, 3, v.Cursor.X)
+	v.Write([]byte(esc("[H") + esc("[99999999999999999999999999C")))
+	assert.Equal(t, 3, v.Cursor.X)
+
+	// Processed commands are limited too, not just written ones.
+	assert.Nil(t, v.Process(cmd(esc("[H"))))
+	assert.Nil(t, v.Process(cmd(esc("[9;9;9;9C"))))
+	assert.Equal(t, 3, v.Cursor.X)
+}
+
+func TestParamLimitsMemory(t *testing.T) {
+	v := NewVT100(1, 10)
+	huge := []byte(esc("["+strings.Repeat("1;", 1<<20)+strings.Repeat("9", 1<<20)+"m") + "a")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	v.Write(huge)
+	runtime.ReadMemStats(&after)
+
+	assert.True(t, after.TotalAlloc-before.TotalAlloc < 64<<10, "allocated %d bytes", after.TotalAlloc-before.TotalAlloc)
+	assert.Equal(t, 'a', v.Content[0][0])
+	assert.Equal(t, Bold, v.Format[0][0].Intensity)
+}
+
+func TestHugeParams(t *testing.T) {
+	for _, tc := range []struct {
+		seq  string
+		y, x int
+		// autoY is the cursor's row with AutoResizeY, if it differs.
+		autoY int
+	}{
+		{"[2147483647B", 23, 1, 23},
+		{"[999999999@", 0, 1, 0},
+		// CUP only moves the cursor; the terminal grows when it's written to,
+		// but not by more than 1000 rows at once.
+		{"[999999999;999999999H", 23, 79, 1023},
+		{"[999999999L", 0, 1, 0},
+		{"[999999999S", 0, 1, 0},
+		{"[999999999C", 0, 79, 0},
+		{"[999999999T", 0, 1, 0},
+	} {
+		for _, auto := range []bool{false, true} {
+			v := NewVT100(24, 80)
+			v.AutoResizeY = auto
+
+			start := time.Now()
+			v.Write([]byte("x" + esc(tc.seq)))
+			assert.True(t, time.Since(start) < time.Second, "%q took %s", tc.seq, time.Since(start))
+
+			y := tc.y
+			if auto {
+				y = tc.autoY
+			}
+			assert.Equal(t, 24, v.Height, "after %q", tc.seq)
+			assert.Equal(t, 80, v.Width, "after %q", tc.seq)
+			assert.Equal(t, y, v.Cursor.Y, "after %q", tc.seq)
+			assert.Equal(t, tc.x, v.Cursor.X, "after %q", tc.seq)
+		}
+	}
+
+	v := NewVT100(24, 80)
+	v.AutoResizeY, v.AutoResizeX = true, true
+	v.Write([]byte(esc("[99999;1Hx") + esc("[1;99999Hy")))
+	assert.Equal(t, 1024, v.Height)
+	assert.Equal(t, 1080, v.Width)
+	assert.Equal(t, 'x', v.Content[1023][0])
+	assert.Equal(t, 'y', v.Content[0][1079])
+}
+
+func TestNegativeParams(t *testing.T) {
+	for _, seq := range []string{"[-3A", "[-3B", "[-3C", "[-3D", "[-1;-1H", "[-2S", "[2;-9223372036854775809H"} {
+		v := NewVT100(5, 5)
+		v.Write([]byte(esc("[3;3H")))
+		assert.NotNil(t, v.Process(cmd(esc(seq))), seq)
+		assert.Equal(t, 2, v.Cursor.Y, seq)
+		assert.Equal(t, 2, v.Cursor.X, seq)
+	}
+}
+
+func TestCUPClamping(t *testing.T) {
+	for _, tc := range []struct {
+		autoY, autoX bool
+		h, w         int
+		y, x         int
+	}{
+		{false, false, 24, 80, 23, 79},
+		{true, false, 200, 80, 199, 79},
+		{false, true, 24, 500, 23, 499},
+		{true, true, 200, 500, 199, 499},
+	} {
+		v := NewVT100(24, 80)
+		v.AutoResizeY, v.AutoResizeX = tc.autoY, tc.autoX
+		err := v.Process(cmd(esc("[200;500H")))
+		if tc.autoY && tc.autoX {
+			assert.Nil(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+
+		v.Write([]byte("x"))
+		assert.Equal(t, tc.h, v.Height, "with auto-resize %v, %v", tc.autoY, tc.autoX)
+		assert.Equal(t, tc.w, v.Width, "with auto-resize %v, %v", tc.autoY, tc.autoX)
+		assert.Equal(t, 'x', v.Content[tc.y][tc.x], "with auto-resize %v, %v", tc.autoY, tc.autoX)
+	}
+}
+
+func TestCommandString(t *testing.T) {
+	for in, want := range map[string]string{
+		esc("[3;1H"):      "CUP(3,1)",
+		esc("[H"):         "CUP()",
+		esc("[5A"):        "CUU(5)",
+		esc("[1;31m"):     "SGR[1;31]",
+		esc("[K"):         "ERASE(line,forward)",
+		esc("[2J"):        "ERASE(display,all)",
+		esc("[?1K"):       "ERASE(line,back,selective)",
+		esc("[?25;1h"):    "DECSET(25,1)",
+		esc(`[1"q`):       "DECSCA(1)",
+		esc("(0"):         "SCS0(0)",
+		esc("7"):          "DECSC()",
+		esc("[5;6y"):      `ESC("5;6" 'y')`,
+		esc("]0;title\a"): `OSC("0;title")`,
+		"a":               "RUNE('a')",
+		"\r":              "CTRL(CR)",
+	} {
+		assert.Equal(t, want, cmd(in).String(), "for %q", in)
+	}
+
+	assert.Equal(t, `escapeCommand{'H', "3;1"}`, fmt.Sprintf("%#v", cmd(esc("[3;1H"))))
+	assert.Equal(t, `runeCommand('a')`, fmt.Sprintf("%#v", cmd("a")))
+	assert.Equal(t, `controlCommand('\n')`, fmt.Sprintf("%#v", cmd("\n")))
+	assert.Equal(t, `stringCommand{']', "0;t"}`, fmt.Sprintf("%#v", cmd(esc("]0;t\a"))))
+
+	// Errors say which command they came from.
+	err := NewVT100(1, 1).Process(cmd(esc(`[3;"x"H`)))
+	assert.Contains(t, err.Error(), `CUP(3,"x"): while parsing int args`)
+	err = NewVT100(1, 1).Process(cmd(esc("[5;5H")))
+	assert.Equal(t, "CUP(5,5): out of bounds (4, 4)", err.Error())
+	err = NewVT100(1, 1).Process(cmd(esc("[5;6y")))
+	assert.Equal(t, `ESC("5;6" 'y'): unsupported command`, err.Error())
+}
+
+func TestEncode(t *testing.T) {
+	for in, want := range map[string]string{
+		"a":                       "a",
+		"ü":                       "ü",
+		"\r":                      "\r",
+		esc("[1;31m"):             esc("[1;31m"),
+		"\u009b5A":                esc("[5A"),
+		esc("(0"):                 esc("(0"),
+		esc("7"):                  esc("7"),
+		esc("]0;title\a"):         esc("]0;title") + esc("\\"),
+		esc("P1$r0m") + esc("\\"): esc("P1$r0m") + esc("\\"),
+	} {
+		var buf bytes.Buffer
+		assert.Nil(t, cmd(in).Encode(&buf))
+		assert.Equal(t, want, buf.String(), "encoding %q", in)
+	}
+
+	// Decoding what's encoded gives the same commands back, for a corpus
+	// made of pieces of likely sequences.
+	pieces := []string{"a", "ü", "\r", "\n", "\x1b", "[", "]", "(", "#", "?", ";", ":", "1", "0", "\"", "m", "H", "q", " ", "\a", "\\", "P", "\u009b", "\u0085", "\x7f"}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		var in strings.Builder
+		for j := 0; j < 20; j++ {
+			in.WriteString(pieces[rng.Intn(len(pieces))])
+		}
+
+		r := strings.NewReader(in.String())
+		for {
+			c, err := Decode(r)
+			if err != nil {
+				break
+			}
+			var buf bytes.Buffer
+			assert.Nil(t, c.Encode(&buf))
+			again, err := Decode(bytes.NewReader(buf.Bytes()))
+			assert.Nil(t, err)
+			assert.Equal(t, c, again, "encoded as %q, in %q", buf.Bytes(), in.String())
+		}
+	}
+
+	// Unsupported commands passed to Process know how they're encoded.
+	var ue UnsupportedError
+	assert.True(t, errors.As(NewVT100(1, 1).Process(cmd("\u009b5;6y")), &ue))
+	assert.Equal(t, []byte(esc("[5;6y")), ue.Raw)
+}
+
+func TestProcessAll(t *testing.T) {
+	v := NewVT100(2, 5)
+	updates := v.Updates()
+
+	err := v.ProcessAll(cmds("ab" + esc("[5y") + esc("[1m") + "c" + esc("[77m")))
+	var ue UnsupportedError
+	assert.True(t, errors.As(err, &ue))
+	assert.Contains(t, err.Error(), "unsupported command")
+	assert.Contains(t, err.Error(), "unknown attributes: [77]")
+	assert.Equal(t, "abc  ", string(v.Content[0]))
+	assert.Equal(t, Bold, v.Format[0][2].Intensity)
+
+	// Listeners are told once.
+	<-updates
+	select {
+	case <-updates:
+		t.Error("notified more than once")
+	default:
+	}
+
+	assert.Nil(t, v.ProcessAll(nil))
+	assert.Nil(t, v.ProcessString("\r\n"+esc("[31m")+"d"))
+	assert.Equal(t, "d    ", string(v.Content[1]))
+	assert.Equal(t, termenv.ANSIRed, v.Format[1][0].Fg)
+
+	err = v.ProcessString("e\xff" + esc("[5y") + esc("[1"))
+	assert.Contains(t, err.Error(), "non-utf8")
+	assert.True(t, errors.As(err, &ue))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	assert.Equal(t, "de\ufffd  ", string(v.Content[1]))
+}
+
+func TestUnsupportedError(t *testing.T) {
+	v := NewVT100(1, 10)
+
+	var ue UnsupportedError
+	err := v.Process(cmd(esc("[5;6y")))
+	assert.True(t, errors.As(err, &ue))
+	assert.Equal(t, "command 'y'", ue.Kind)
+	assert.Equal(t, []int{5, 6}, ue.Params)
+
+	err = v.Process(cmd(esc("[1;77m")))
+	assert.True(t, errors.As(err, &ue))
+	assert.Equal(t, "SGR attribute", ue.Kind)
+	assert.Equal(t, []int{77}, ue.Params)
+
+	// Written sequences are logged along with their raw bytes.
+	var logs bytes.Buffer
+	v.DebugLogs = &logs
+	v.Write([]byte("a" + esc("[5;6y") + "b"))
+	assert.Contains(t, logs.String(), hex.Dump([]byte(esc("[5;6y"))))
+	assert.Equal(t, "ab", string(v.Content[0][:2]))
+}
@@ -0,0 +1,123 @@
+package vt100
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/muesli/termenv"
+)
+
+// MoveTo returns a command (CUP) that moves the cursor to the 0-indexed row y
+// and column x.
+func MoveTo(y, x int) Command {
+	return escapeCommand{'H', strconv.Itoa(y+1) + ";" + strconv.Itoa(x+1)}
+}
+
+// SetFormat returns a command (SGR) that sets the format that text is
+// written in to f, starting from the default.
+func SetFormat(f Format) Command {
+	params := []string{"0"}
+	add := func(p ...string) {
+		params = append(params, p...)
+	}
+
+	switch f.Intensity {
+	case Bold:
+		add("1")
+	case Faint:
+		add("2")
+	}
+	if f.Italic {
+		add("3")
+	}
+	if f.UnderlineStyle != NoUnderline {
+		add("4:" + strconv.Itoa(int(f.UnderlineStyle)))
+	} else if f.Underline {
+		add("4")
+	}
+	if f.Blink {
+		add("5")
+	}
+	if f.RapidBlink {
+		add("6")
+	}
+	if f.Reverse {
+		add("7")
+	}
+	if f.Conceal {
+		add("8")
+	}
+	if f.CrossOut {
+		add("9")
+	}
+	if f.Overline {
+		add("53")
+	}
+	add(sgrColor(f.Fg, 30, 90, 38)...)
+	add(sgrColor(f.Bg, 40, 100, 48)...)
+	add(sgrColor(f.UnderlineColor, -1, -1, 58)...)
+
+	return escapeCommand{'m', strings.Join(params, ";")}
+}
+
+// sgrColor returns the SGR parameters that set c: base or bright plus the
+// index of an ANSI color, or extended followed by the palette index or
+// components of any other. ANSI colors are treated as palette indexes if base
+// is negative.
+func sgrColor(c termenv.Color, base, bright, extended int) []string {
+	switch c := c.(type) {
+	case nil:
+		return nil
+	case termenv.ANSIColor:
+		if base >= 0 && c < 8 {
+			return []string{strconv.Itoa(base + int(c))}
+		}
+		if base >= 0 && c < 16 {
+			return []string{strconv.Itoa(bright + int(c) - 8)}
+		}
+		return []string{strconv.Itoa(extended), "5", strconv.Itoa(int(c))}
+	case termenv.ANSI256Color:
+		return []string{strconv.Itoa(extended), "5", strconv.Itoa(int(c))}
+	default:
+		r, g, b := termenv.ConvertToRGB(c).RGB255()
+		return []string{strconv.Itoa(extended), "2", strconv.Itoa(int(r)), strconv.Itoa(int(g)), strconv.Itoa(int(b))}
+	}
+}
+
+// EraseLine returns a command (EL) that erases the cursor's line in the
+// direction d.
+func EraseLine(d EraseDirection) Command {
+	return escapeCommand{'K', strconv.Itoa(int(d))}
+}
+
+// EraseDisplay returns a command (ED) that erases the screen in the direction
+// d.
+func EraseDisplay(d EraseDirection) Command {
+	return escapeCommand{'J', strconv.Itoa(int(d))}
+}
+
+// Text returns the commands that put each rune of s, or carry out each
+// control character in it, as writing s would if it held no escape
+// sequences.
+func Text(s string) []Command {
+	cmds := make([]Command, 0, len(s))
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			cmds = append(cmds, controlCommand(r))
+		} else {
+			cmds = append(cmds, runeCommand(r))
+		}
+	}
+	return cmds
+}
+
+// Scroll returns a command (SU) that scrolls the content of the terminal up
+// by n lines, or down (SD) if n is negative, without moving the cursor. As
+// with SU, scrolling by 0 lines scrolls by 1.
+func Scroll(n int) Command {
+	if n < 0 {
+		return escapeCommand{'T', strconv.Itoa(-n)}
+	}
+	return escapeCommand{'S', strconv.Itoa(n)}
+}
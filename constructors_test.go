@@ -0,0 +1,54 @@
+package vt100_test
+
+import (
+	"testing"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+	"github.com/vito/vt100/vttest"
+)
+
+func TestConstructors(t *testing.T) {
+	assert.Equal(t, cmd(esc("[3;5H")), MoveTo(2, 4))
+	assert.Equal(t, cmd(esc("[0;1;31m")), SetFormat(Format{Intensity: Bold, Fg: termenv.ANSIRed}))
+	assert.Equal(t, cmd(esc("[1K")), EraseLine(EraseBack))
+	assert.Equal(t, cmd(esc("[2J")), EraseDisplay(EraseAll))
+	assert.Equal(t, cmd(esc("[2S")), Scroll(2))
+	assert.Equal(t, cmd(esc("[3T")), Scroll(-3))
+	assert.Equal(t, cmds("aü\r\n"), Text("aü\r\n"))
+}
+
+func TestSetFormatCommand(t *testing.T) {
+	for _, f := range []Format{
+		{},
+		{Intensity: Faint, Italic: true, Blink: true, Reverse: true, Conceal: true, CrossOut: true, Overline: true},
+		{Underline: true, UnderlineStyle: CurlyUnderline, UnderlineColor: termenv.ANSI256Color(200)},
+		{Underline: true, UnderlineStyle: SingleUnderline, RapidBlink: true},
+		{Fg: termenv.ANSIBrightCyan, Bg: termenv.ANSIBlue},
+		{Fg: termenv.ANSI256Color(100), Bg: termenv.RGBColor("#102030"), UnderlineColor: termenv.ANSIRed},
+	} {
+		v := NewVT100(1, 1)
+		v.Write([]byte(esc("[1;4;35m")))
+		assert.Nil(t, v.Process(SetFormat(f)))
+		f.Reset = true
+		assert.Equal(t, f, v.Cursor.F)
+	}
+}
+
+func TestScroll(t *testing.T) {
+	v := vttest.FromLines("ab\ncd\nef")
+	v.Cursor.Y, v.Cursor.X = 1, 1
+
+	assert.Nil(t, v.ProcessAll([]Command{Scroll(1)}))
+	assert.Equal(t, splitLines("cd\nef\n  "), v.Content)
+	assert.Equal(t, Cursor{Y: 1, X: 1}, v.Cursor)
+
+	assert.Nil(t, v.ProcessAll([]Command{Scroll(-2)}))
+	assert.Equal(t, splitLines("  \n  \ncd"), v.Content)
+	assert.Equal(t, Cursor{Y: 1, X: 1}, v.Cursor)
+
+	// Scrolling further than the height clears everything.
+	v.Write([]byte(esc("[9T")))
+	assert.Equal(t, splitLines("  \n  \n  "), v.Content)
+}
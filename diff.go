@@ -0,0 +1,53 @@
+package vt100
+
+import (
+	"strings"
+)
+
+// The SGR sequences DiffANSI marks cells with.
+const (
+	diffUnchanged = "\x1b[2;90m"
+	diffChanged   = "\x1b[42m"
+	diffRemoved   = "\x1b[41m"
+	diffReset     = "\x1b[0m"
+)
+
+// DiffANSI renders new as text to be written to a terminal, colored to show
+// how it differs from old: cells that changed have a green background, cells
+// that are now blank but weren't in old show their old rune on a red
+// background, and cells that didn't change are dim gray. Formats aren't
+// rendered, but a cell whose format changed counts as changed.
+//
+// Cells outside of old, if new is bigger, count as blank in old.
+func DiffANSI(old, new *VT100) string {
+	o, n := old.Snapshot(), new.Snapshot()
+
+	var b strings.Builder
+	for y, row := range n.Content {
+		style := ""
+		for x, r := range row {
+			was, wasF := ' ', Format{}
+			if y < len(o.Content) && x < len(o.Content[y]) {
+				was, wasF = o.Content[y][x], o.Format[y][x]
+			}
+
+			cellStyle := diffUnchanged
+			switch {
+			case r == was && n.Format[y][x] == wasF:
+			case r == ' ' && was != ' ':
+				cellStyle, r = diffRemoved, was
+			default:
+				cellStyle = diffChanged
+			}
+			if cellStyle != style {
+				// Reset first, so that the dimness of unchanged cells
+				// doesn't carry over.
+				b.WriteString(diffReset + cellStyle)
+				style = cellStyle
+			}
+			b.WriteRune(r)
+		}
+		b.WriteString(diffReset + "\n")
+	}
+	return b.String()
+}
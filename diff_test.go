@@ -0,0 +1,40 @@
+package vt100_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+func TestDiffANSI(t *testing.T) {
+	old := NewVT100(2, 5)
+	old.Write([]byte("abcd\r\nefgh"))
+
+	new := NewVT100(2, 5)
+	new.Write([]byte("abXd\r\ne" + esc("[31m") + "f"))
+
+	const (
+		dim   = "\x1b[0m\x1b[2;90m"
+		green = "\x1b[0m\x1b[42m"
+		red   = "\x1b[0m\x1b[41m"
+		reset = "\x1b[0m"
+	)
+	assert.Equal(t, strings.Join([]string{
+		dim + "ab" + green + "X" + dim + "d " + reset,
+		dim + "e" + green + "f" + red + "gh" + dim + " " + reset,
+	}, "\n")+"\n", DiffANSI(old, new))
+
+	same := DiffANSI(old, old)
+	assert.Equal(t, dim+"abcd "+reset+"\n"+dim+"efgh "+reset+"\n", same)
+	assert.NotContains(t, same, "42m")
+	assert.NotContains(t, same, "41m")
+
+	// Cells beyond old's edges count as blank in old.
+	bigger := NewVT100(3, 6)
+	bigger.Write([]byte("abcd !"))
+	lines := strings.Split(DiffANSI(old, bigger), "\n")
+	assert.Equal(t, dim+"abcd "+green+"!"+reset, lines[0])
+	assert.Equal(t, dim+"      "+reset, lines[2])
+}
@@ -0,0 +1,237 @@
+package vt100
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/muesli/termenv"
+)
+
+// dumpVersion heads every dump. It's changed whenever the format changes in
+// a way that older versions of Load couldn't read.
+const dumpVersion = "vt100-dump 1"
+
+// Dump describes the dimensions, cursor, and cells of the terminal in a
+// stable, human-readable form, which Load turns back into a terminal. Modes,
+// margins, and the like aren't included.
+//
+// For example:
+//
+//	vt100-dump 1
+//	size 2 4
+//	cursor 1 0 bold
+//	"ab  "
+//	  0-1 fg=red
+//	"cd  "
+//
+// Each row's text is quoted, and followed by the runs of cells in it, by
+// column, that don't have the default format. Formats are described by the
+// same tokens as DebugDump.
+func (v *VT100) Dump() string {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	var b strings.Builder
+	fmt.Fprintln(&b, dumpVersion)
+	fmt.Fprintf(&b, "size %d %d\n", v.Height, v.Width)
+	fmt.Fprintf(&b, "cursor %d %d", v.Cursor.Y, v.Cursor.X)
+	if f := v.Cursor.F; f != (Format{}) {
+		b.WriteString(" " + f.dumpTokens())
+	}
+	b.WriteString("\n")
+
+	for y, row := range v.Content {
+		b.WriteString(strconv.Quote(string(row)) + "\n")
+		for x := 0; x < len(row); {
+			f := v.Format[y][x]
+			end := x
+			for end+1 < len(row) && v.Format[y][end+1] == f {
+				end++
+			}
+			if f != (Format{}) {
+				fmt.Fprintf(&b, "  %d-%d %s\n", x, end, f.dumpTokens())
+			}
+			x = end + 1
+		}
+	}
+	return b.String()
+}
+
+// dumpTokens is like String, but also includes "underline" if Underline is
+// set along with an UnderlineStyle, so that f can be recovered exactly.
+func (f Format) dumpTokens() string {
+	s := f.String()
+	if f.Underline && f.UnderlineStyle != NoUnderline {
+		// The tokens are sorted, so "underline" goes just before its style.
+		s = strings.Replace(s, "underline=", "underline,underline=", 1)
+	}
+	return s
+}
+
+// Load makes a terminal from a dump made by Dump.
+func Load(dump string) (*VT100, error) {
+	lines := bufio.NewScanner(strings.NewReader(dump))
+	lines.Buffer(nil, len(dump)+1)
+	n := 0
+	next := func() (string, bool) {
+		if !lines.Scan() {
+			return "", false
+		}
+		n++
+		return lines.Text(), true
+	}
+
+	if line, _ := next(); line != dumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %q, want %q", line, dumpVersion)
+	}
+
+	var h, w int
+	line, _ := next()
+	if _, err := fmt.Sscanf(line, "size %d %d", &h, &w); err != nil {
+		return nil, fmt.Errorf("line %d: invalid size %q: %w", n, line, err)
+	}
+	v, err := New(WithSize(h, w))
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", n, err)
+	}
+
+	line, _ = next()
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) < 3 || fields[0] != "cursor" {
+		return nil, fmt.Errorf("line %d: invalid cursor %q", n, line)
+	}
+	y, errY := strconv.Atoi(fields[1])
+	x, errX := strconv.Atoi(fields[2])
+	if errY != nil || errX != nil || y < 0 || y > h || x < 0 || x > w {
+		return nil, fmt.Errorf("line %d: invalid cursor %q", n, line)
+	}
+	v.Cursor.Y, v.Cursor.X = y, x
+	if len(fields) == 4 {
+		if v.Cursor.F, err = parseTokens(fields[3]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+
+	row := -1
+	for {
+		line, ok := next()
+		if !ok {
+			break
+		}
+
+		if !strings.HasPrefix(line, "  ") {
+			row++
+			text, err := strconv.Unquote(line)
+			if err != nil || row >= h {
+				return nil, fmt.Errorf("line %d: invalid row %q", n, line)
+			}
+			runes := []rune(text)
+			if len(runes) != w {
+				return nil, fmt.Errorf("line %d: row is %d wide, want %d", n, len(runes), w)
+			}
+			copy(v.Content[row], runes)
+			continue
+		}
+
+		var x1, x2 int
+		var tokens string
+		if _, err := fmt.Sscanf(line, "  %d-%d %s", &x1, &x2, &tokens); err != nil || row < 0 || x1 < 0 || x1 > x2 || x2 >= w {
+			return nil, fmt.Errorf("line %d: invalid format run %q", n, line)
+		}
+		f, err := parseTokens(tokens)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		for x := x1; x <= x2; x++ {
+			v.Format[row][x] = f
+		}
+	}
+	if row != h-1 {
+		return nil, fmt.Errorf("dump has %d rows, want %d", row+1, h)
+	}
+
+	return v, nil
+}
+
+// parseTokens parses a format described by Format.dumpTokens.
+func parseTokens(s string) (Format, error) {
+	var f Format
+	for _, token := range strings.Split(s, ",") {
+		name, value := token, ""
+		if i := strings.IndexByte(token, '='); i >= 0 {
+			name, value = token[:i], token[i+1:]
+		}
+
+		var err error
+		switch name {
+		case "fg":
+			f.Fg, err = parseColorName(value)
+		case "bg":
+			f.Bg, err = parseColorName(value)
+		case "ul":
+			f.UnderlineColor, err = parseColorName(value)
+		case "bold":
+			f.Intensity = Bold
+		case "faint":
+			f.Intensity = Faint
+		case "underline":
+			if value == "" {
+				f.Underline = true
+				break
+			}
+			err = fmt.Errorf("unknown underline style %q", value)
+			for style, name := range underlineStyleNames {
+				if name == value {
+					f.UnderlineStyle, err = style, nil
+				}
+			}
+		case "reset":
+			f.Reset = true
+		case "italic":
+			f.Italic = true
+		case "blink":
+			f.Blink = true
+		case "rapidblink":
+			f.RapidBlink = true
+		case "reverse":
+			f.Reverse = true
+		case "conceal":
+			f.Conceal = true
+		case "crossout":
+			f.CrossOut = true
+		case "overline":
+			f.Overline = true
+		case "protected":
+			f.Protected = true
+		default:
+			err = fmt.Errorf("unknown format %q", token)
+		}
+		if err != nil {
+			return Format{}, err
+		}
+	}
+	return f, nil
+}
+
+// parseColorName parses a color named by colorName.
+func parseColorName(s string) (termenv.Color, error) {
+	for i, name := range ansiColorNames {
+		if name == s {
+			return termenv.ANSIColor(i), nil
+		}
+	}
+	if strings.HasPrefix(s, "#") && len(s) == 7 {
+		if _, err := strconv.ParseUint(s[1:], 16, 32); err == nil {
+			return termenv.RGBColor(s), nil
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(s, "ansi")); err == nil {
+		if strings.HasPrefix(s, "ansi") {
+			return termenv.ANSIColor(n), nil
+		}
+		return termenv.ANSI256Color(n), nil
+	}
+	return nil, fmt.Errorf("unknown color %q", s)
+}
@@ -0,0 +1,46 @@
+package vt100_test
+
+import (
+	"testing"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+func TestDumpAndLoad(t *testing.T) {
+	v := NewVT100(3, 6)
+	v.Write([]byte(esc("[1;31m") + "ab" + esc("[0;4:3;58;2;255;128;0m") + "c" +
+		esc("[0;4;48;5;200m") + "d\r\n" + esc("[0;7;9;53;3;6m") + "\"é\"" +
+		esc("[0;2;8m") + "\\" + esc("[0m") + "\r\n\tx" + esc("[38;5;200;44m")))
+	v.SetCell(2, 5, Cell{Rune: '\a', Format: Format{Reset: true, Protected: true, Underline: true, UnderlineStyle: DoubleUnderline}})
+
+	dump := v.Dump()
+	assert.Contains(t, dump, "vt100-dump 1\nsize 3 6\ncursor 2 5 reset,bg=blue,fg=200\n\"abcd  \"\n  0-1 bold,fg=red\n")
+
+	loaded, err := Load(dump)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, v.ContentEqual(loaded), "loaded:\n%s", loaded.Dump())
+	assert.Equal(t, v.Cursor, loaded.Cursor)
+	assert.Equal(t, dump, loaded.Dump())
+	assert.Equal(t, termenv.ANSI256Color(200), loaded.Cursor.F.Fg)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load("vt100-dump 0\nsize 1 1\ncursor 0 0\n\" \"\n")
+	assert.EqualError(t, err, `unsupported dump version "vt100-dump 0", want "vt100-dump 1"`)
+
+	for _, dump := range []string{
+		"vt100-dump 1\nsize 0 1\ncursor 0 0\n",
+		"vt100-dump 1\nsize 1 1\ncursor 0 2\n\" \"\n",
+		"vt100-dump 1\nsize 1 1\ncursor 0 0\n\"  \"\n",
+		"vt100-dump 1\nsize 2 1\ncursor 0 0\n\" \"\n",
+		"vt100-dump 1\nsize 1 1\ncursor 0 0\n\" \"\n  0-0 sparkly\n",
+		"vt100-dump 1\nsize 1 1\ncursor 0 0\n\" \"\n  0-1 bold\n",
+	} {
+		_, err := Load(dump)
+		assert.Error(t, err, "loading %q", dump)
+	}
+}
@@ -0,0 +1,80 @@
+package vt100
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Event describes a command applied to a VT100, as written to EventLog.
+type Event struct {
+	// Op is the kind of command: "print", "move", "erase", "scroll", "sgr",
+	// "control" for other control characters, or "other".
+	Op string `json:"op"`
+
+	// Command describes the command itself, as by its String method.
+	Command string `json:"command"`
+
+	// Y and X are the position of the cursor after the command.
+	Y int `json:"y"`
+	X int `json:"x"`
+
+	// Error is the error from the command, if there was one.
+	Error string `json:"error,omitempty"`
+}
+
+// logEvent writes an Event for c, which returned err, to EventLog.
+func (v *VT100) logEvent(c Command, err error) {
+	e := Event{
+		Op:      eventOp(c),
+		Command: c.String(),
+		Y:       v.Cursor.Y,
+		X:       v.Cursor.X,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	json.NewEncoder(v.EventLog).Encode(e)
+}
+
+// eventOp returns the Op of an Event for c.
+func eventOp(c Command) string {
+	switch c := c.(type) {
+	case runeCommand:
+		return "print"
+	case controlCommand:
+		switch c {
+		case backspace, horizontalTab, linefeed, carriageReturn:
+			return "move"
+		}
+		return "control"
+	case escapeCommand:
+		if _, _, ok := c.intermediate(); ok {
+			return "other"
+		}
+		private := strings.HasPrefix(c.args, "?")
+		switch c.cmd {
+		case 'J', 'K':
+			return "erase"
+		case 'A', 'B', 'C', 'D', 'G', 'H', 'f', 'u', '7', '8':
+			if !private {
+				return "move"
+			}
+		case 'S', 'T':
+			if !private {
+				return "scroll"
+			}
+		case 'm':
+			if !private {
+				return "sgr"
+			}
+		}
+	case vt52Command:
+		switch c.cmd {
+		case 'J', 'K':
+			return "erase"
+		case 'A', 'B', 'C', 'D', 'H', 'Y':
+			return "move"
+		}
+	}
+	return "other"
+}
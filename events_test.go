@@ -0,0 +1,34 @@
+package vt100_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+func TestEventLog(t *testing.T) {
+	v := NewVT100(2, 4)
+	var log bytes.Buffer
+	v.EventLog = &log
+
+	v.Write([]byte("a" + esc("[1m") + "\r\n" + esc("[K") + esc("[S") + esc("[1;3H") + esc("[5;6y") + "\a"))
+
+	var ops []string
+	dec := json.NewDecoder(&log)
+	var events []Event
+	for dec.More() {
+		var e Event
+		assert.Nil(t, dec.Decode(&e))
+		events = append(events, e)
+		ops = append(ops, e.Op)
+	}
+	assert.Equal(t, []string{"print", "sgr", "move", "move", "erase", "scroll", "move", "other", "control"}, ops)
+
+	assert.Equal(t, Event{Op: "print", Command: "RUNE('a')", Y: 0, X: 1}, events[0])
+	assert.Equal(t, Event{Op: "move", Command: "CUP(1,3)", Y: 0, X: 2}, events[6])
+	assert.Equal(t, `ESC("5;6" 'y')`, events[7].Command)
+	assert.Contains(t, events[7].Error, "unsupported")
+}
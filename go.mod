@@ -1,11 +1,20 @@
 module github.com/vito/vt100
 
-go 1.12
+go 1.20
 
 require (
-	github.com/mattn/go-isatty v0.0.18 // indirect
 	github.com/muesli/termenv v0.15.1
-	github.com/rivo/uniseg v0.4.4 // indirect
 	github.com/stretchr/testify v1.3.0
-	golang.org/x/sys v0.7.0 // indirect
+	golang.org/x/image v0.18.0
+)
+
+require (
+	github.com/aymanbagabas/go-osc52/v2 v2.0.1 // indirect
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/lucasb-eyer/go-colorful v1.2.0 // indirect
+	github.com/mattn/go-isatty v0.0.18 // indirect
+	github.com/mattn/go-runewidth v0.0.14 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/rivo/uniseg v0.4.4 // indirect
+	golang.org/x/sys v0.6.0 // indirect
 )
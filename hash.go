@@ -0,0 +1,77 @@
+package vt100
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+)
+
+// Hash returns an FNV-1a hash of the dimensions, runes, and formats of the
+// terminal. Terminals for which ContentEqual is true have the same hash, so
+// it's a cheap way to tell whether a terminal has changed. The cursor isn't
+// included, so moving it alone doesn't change the hash.
+//
+// The hash is kept until the terminal is next changed by one of its methods.
+// Changes made directly to Content or Format aren't noticed.
+func (v *VT100) Hash() uint64 {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	// Several readers may get here at once, so the cache needs a lock of its
+	// own.
+	v.hashMut.Lock()
+	defer v.hashMut.Unlock()
+
+	if !v.hashValid {
+		v.hash = v.computeHash()
+		v.hashValid = true
+	}
+	return v.hash
+}
+
+// Fingerprint is Hash by another name, for telling whether the screen has
+// changed from one frame to the next. It takes in the runes and formats of
+// the cells, along with the terminal's dimensions, but not the cursor.
+func (v *VT100) Fingerprint() uint64 {
+	return v.Hash()
+}
+
+func (v *VT100) computeHash() uint64 {
+	h := fnv.New64a()
+	var buf [8]byte
+	writeInt := func(n int) {
+		binary.LittleEndian.PutUint64(buf[:], uint64(n))
+		h.Write(buf[:])
+	}
+
+	writeInt(v.Height)
+	writeInt(v.Width)
+
+	// Each format is written out in full the first time it's seen, and by
+	// the order it was first seen in after that.
+	formats := map[Format]int{}
+	for y := range v.Content {
+		for x, r := range v.Content[y] {
+			writeInt(int(r))
+
+			f := v.Format[y][x]
+			id, ok := formats[f]
+			if !ok {
+				id = len(formats)
+				formats[f] = id
+				tokens := f.String()
+				writeInt(-len(tokens) - 1)
+				io.WriteString(h, tokens)
+			}
+			writeInt(id)
+		}
+	}
+
+	return h.Sum64()
+}
+
+// invalidateHash forgets the hash computed by Hash. It must be called with
+// v.mut held.
+func (v *VT100) invalidateHash() {
+	v.hashValid = false
+}
@@ -0,0 +1,65 @@
+package vt100_test
+
+import (
+	"testing"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+func TestHash(t *testing.T) {
+	a := NewVT100(2, 4)
+	b := NewVT100(2, 4)
+	a.Write([]byte("ab" + esc("[31m") + "cd"))
+	b.Write([]byte(esc("[H") + "ab" + esc("[31m") + "cd"))
+	assert.True(t, a.ContentEqual(b))
+	assert.Equal(t, a.Hash(), b.Hash())
+
+	// Any one cell's rune or format changes it.
+	hash := a.Hash()
+	for y := 0; y < a.Height; y++ {
+		for x := 0; x < a.Width; x++ {
+			c, _ := a.CellAt(y, x)
+
+			a.SetCell(y, x, Cell{Rune: 'z', Format: c.Format})
+			assert.NotEqual(t, hash, a.Hash(), "rune at %d, %d", y, x)
+			a.SetCell(y, x, c)
+			assert.Equal(t, hash, a.Hash())
+
+			a.SetCell(y, x, Cell{Rune: c.Rune, Format: Format{Bg: termenv.ANSIBlue}})
+			assert.NotEqual(t, hash, a.Hash(), "format at %d, %d", y, x)
+			a.SetCell(y, x, c)
+			assert.Equal(t, hash, a.Hash())
+		}
+	}
+
+	// Moving the cursor doesn't.
+	a.Write([]byte(esc("[H")))
+	assert.Equal(t, hash, a.Hash())
+
+	a.Write([]byte(esc("[2H") + "e"))
+	assert.NotEqual(t, hash, a.Hash())
+	b.Write([]byte("e"))
+	assert.Equal(t, a.Hash(), b.Hash())
+
+	hash = a.Hash()
+	a.Resize(2, 5)
+	assert.NotEqual(t, hash, a.Hash())
+}
+
+func TestFingerprint(t *testing.T) {
+	a := NewVT100(2, 3)
+	b := NewVT100(2, 3)
+	a.Write([]byte("ab" + esc("[1m") + "c\r\nd"))
+	b.Write([]byte("ab" + esc("[1m") + "c\r\nd" + esc("[H")))
+	assert.Equal(t, a.Fingerprint(), b.Fingerprint())
+
+	b.SetCell(1, 2, Cell{Rune: 'e'})
+	assert.NotEqual(t, a.Fingerprint(), b.Fingerprint())
+	b.SetCell(1, 2, Cell{Rune: ' '})
+	assert.Equal(t, a.Fingerprint(), b.Fingerprint())
+
+	b.SetCell(0, 0, Cell{Rune: 'a', Format: Format{Italic: true}})
+	assert.NotEqual(t, a.Fingerprint(), b.Fingerprint())
+}
@@ -0,0 +1,64 @@
+package vt100
+
+import "expvar"
+
+// Metrics receives counts of what a VT100 does. Its methods are called once
+// the terminal is unlocked, after each Write or Process.
+type Metrics interface {
+	// UnsupportedCommand is called for each command that was ignored because
+	// it isn't supported, with the Kind of its UnsupportedError.
+	UnsupportedCommand(kind string)
+
+	// BytesWritten is called with the number of bytes written.
+	BytesWritten(n int)
+
+	// CommandsProcessed is called with the number of commands carried out,
+	// counting each printed rune as one.
+	CommandsProcessed(n int)
+}
+
+// ExpvarMetrics is a Metrics that adds to process-wide expvars, which can be
+// examined in /debug/vars of a debug http server:
+//
+//	vt100-unsupported-commands, a map of the counts of each kind
+//	vt100-bytes-written
+//	vt100-commands-processed
+type ExpvarMetrics struct{}
+
+var (
+	unsupportedCommands = expvar.NewMap("vt100-unsupported-commands")
+	bytesWritten        = expvar.NewInt("vt100-bytes-written")
+	commandsProcessed   = expvar.NewInt("vt100-commands-processed")
+)
+
+func (ExpvarMetrics) UnsupportedCommand(kind string) {
+	unsupportedCommands.Add(kind, 1)
+}
+
+func (ExpvarMetrics) BytesWritten(n int) {
+	bytesWritten.Add(int64(n))
+}
+
+func (ExpvarMetrics) CommandsProcessed(n int) {
+	commandsProcessed.Add(int64(n))
+}
+
+// metricCounts accumulates metrics while a VT100 is locked, to be reported
+// once it's unlocked.
+type metricCounts struct {
+	bytes, commands int
+	unsupported     []string
+}
+
+// report passes the counts on to m.
+func (c metricCounts) report(m Metrics) {
+	if c.bytes > 0 {
+		m.BytesWritten(c.bytes)
+	}
+	if c.commands > 0 {
+		m.CommandsProcessed(c.commands)
+	}
+	for _, kind := range c.unsupported {
+		m.UnsupportedCommand(kind)
+	}
+}
@@ -0,0 +1,73 @@
+package vt100_test
+
+import (
+	"expvar"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+type fakeMetrics struct {
+	v           *VT100
+	unsupported []string
+	bytes       int
+	commands    int
+}
+
+func (m *fakeMetrics) UnsupportedCommand(kind string) {
+	m.unsupported = append(m.unsupported, kind)
+}
+
+func (m *fakeMetrics) BytesWritten(n int) {
+	// The terminal isn't locked, so this would deadlock otherwise.
+	m.v.UsedHeight()
+	m.bytes += n
+}
+
+func (m *fakeMetrics) CommandsProcessed(n int) {
+	m.commands += n
+}
+
+func TestMetrics(t *testing.T) {
+	v := NewVT100(2, 10)
+	m := &fakeMetrics{v: v}
+	v.Metrics = m
+
+	in := "ab" + esc("[1m") + "ü" + esc("[5;6y") + esc("[?9999h") + "\r\n"
+	v.Write([]byte(in))
+	assert.Equal(t, len(in), m.bytes)
+	assert.Equal(t, 8, m.commands)
+	assert.Equal(t, []string{"command 'y'", "private mode"}, m.unsupported)
+
+	assert.NotNil(t, v.Process(cmd(esc("[77m"))))
+	assert.Equal(t, len(in), m.bytes)
+	assert.Equal(t, 9, m.commands)
+	assert.Equal(t, []string{"command 'y'", "private mode", "SGR attribute"}, m.unsupported)
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	unsupported := expvar.Get("vt100-unsupported-commands").(*expvar.Map)
+	written := expvar.Get("vt100-bytes-written").(*expvar.Int)
+	before := written.Value()
+	var unsupportedBefore int64
+	if c, ok := unsupported.Get("command 'y'").(*expvar.Int); ok {
+		unsupportedBefore = c.Value()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v := NewVT100(2, 10)
+			v.Metrics = ExpvarMetrics{}
+			v.Write([]byte("abc" + esc("[5;6y")))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, before+4*int64(len("abc"+esc("[5;6y"))), written.Value())
+	assert.Equal(t, unsupportedBefore+4, unsupported.Get("command 'y'").(*expvar.Int).Value())
+}
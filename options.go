@@ -0,0 +1,137 @@
+package vt100
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/muesli/termenv"
+)
+
+// The size of a terminal made by New without WithSize.
+const (
+	DefaultHeight = 24
+	DefaultWidth  = 80
+)
+
+// An Option configures a VT100 made by New.
+type Option func(*VT100) error
+
+// New creates a new VT100 configured by opts. Unless WithSize says otherwise,
+// it's DefaultHeight rows by DefaultWidth columns.
+//
+// Each cell is set to contain a ' ' rune in DefaultFormat.
+func New(opts ...Option) (*VT100, error) {
+	v := &VT100{
+		Height: DefaultHeight,
+		Width:  DefaultWidth,
+
+		MaxOSCLength:  DefaultMaxOSCLength,
+		MaxParams:     DefaultMaxParams,
+		MaxParamValue: DefaultMaxParamValue,
+
+		// start at -1 so there's no "used" height until first write
+		maxY: -1,
+	}
+	for _, opt := range opts {
+		if err := opt(v); err != nil {
+			return nil, err
+		}
+	}
+
+	y, x := v.Height, v.Width
+	v.Content = make([][]rune, y)
+	v.Format = make([][]Format, y)
+	v.LineWrapped = make([]bool, y)
+	v.RightMargin = x - 1
+	v.BottomMargin = y - 1
+	for row := 0; row < y; row++ {
+		v.Content[row] = make([]rune, x)
+		v.Format[row] = make([]Format, x)
+
+		for col := 0; col < x; col++ {
+			v.clear(row, col)
+		}
+	}
+
+	return v, nil
+}
+
+// WithSize sets the number of rows and columns, which must both be greater
+// than zero, or New returns ErrInvalidDimensions.
+func WithSize(h, w int) Option {
+	return func(v *VT100) error {
+		if h <= 0 || w <= 0 {
+			return fmt.Errorf("%w (%d, %d)", ErrInvalidDimensions, h, w)
+		}
+		v.Height, v.Width = h, w
+		return nil
+	}
+}
+
+// WithAutoResize sets AutoResizeX and AutoResizeY.
+func WithAutoResize(x, y bool) Option {
+	return func(v *VT100) error {
+		v.AutoResizeX, v.AutoResizeY = x, y
+		return nil
+	}
+}
+
+// WithMaxSize sets MaxHeight and MaxWidth, which mustn't be negative.
+func WithMaxSize(h, w int) Option {
+	return func(v *VT100) error {
+		if h < 0 || w < 0 {
+			return fmt.Errorf("invalid maximum size (%d, %d)", h, w)
+		}
+		v.MaxHeight, v.MaxWidth = h, w
+		return nil
+	}
+}
+
+// WithOverflow sets Overflow.
+func WithOverflow(o OverflowBehavior) Option {
+	return func(v *VT100) error {
+		if o < OverflowScroll || o > OverflowClamp {
+			return fmt.Errorf("invalid overflow behavior %d", o)
+		}
+		v.Overflow = o
+		return nil
+	}
+}
+
+// WithScrollback keeps up to n of the lines that scroll off the top of the
+// terminal, for ScrollbackLine. n must not be negative; zero, the default,
+// keeps none.
+func WithScrollback(n int) Option {
+	return func(v *VT100) error {
+		if n < 0 {
+			return fmt.Errorf("invalid scrollback %d", n)
+		}
+		v.scrollbackLimit = n
+		return nil
+	}
+}
+
+// WithDefaultColors sets DefaultFg and DefaultBg.
+func WithDefaultColors(fg, bg termenv.Color) Option {
+	return func(v *VT100) error {
+		v.DefaultFg, v.DefaultBg = fg, bg
+		return nil
+	}
+}
+
+// WithDefaultFormat sets DefaultFormat, which the terminal's cells start
+// with.
+func WithDefaultFormat(f Format) Option {
+	return func(v *VT100) error {
+		v.DefaultFormat = f
+		return nil
+	}
+}
+
+// WithDebugWriter sets DebugLogs.
+func WithDebugWriter(w io.Writer) Option {
+	return func(v *VT100) error {
+		v.DebugLogs = w
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package vt100_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+func TestNew(t *testing.T) {
+	v, err := New()
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultHeight, v.Height)
+	assert.Equal(t, DefaultWidth, v.Width)
+
+	var logs bytes.Buffer
+	v, err = New(WithSize(2, 3), WithAutoResize(true, false), WithMaxSize(10, 20), WithDebugWriter(&logs))
+	assert.Nil(t, err)
+	assert.Equal(t, splitLines("   \n   "), v.Content)
+	assert.True(t, v.AutoResizeX)
+	assert.False(t, v.AutoResizeY)
+	assert.Equal(t, 10, v.MaxHeight)
+	assert.Equal(t, 20, v.MaxWidth)
+	assert.Equal(t, &logs, v.DebugLogs)
+
+	for _, opt := range []Option{
+		WithSize(0, 80),
+		WithSize(24, -1),
+		WithScrollback(-1),
+		WithMaxSize(-1, 0),
+		WithOverflow(OverflowBehavior(42)),
+	} {
+		v, err := New(opt)
+		assert.Nil(t, v)
+		assert.NotNil(t, err)
+	}
+
+	assert.Panics(t, func() { NewVT100(0, 0) })
+}
+
+func TestNewVT100Safe(t *testing.T) {
+	for _, dims := range [][2]int{{0, 80}, {24, 0}, {-1, 80}, {24, -1}} {
+		v, err := NewVT100Safe(dims[0], dims[1])
+		assert.Nil(t, v)
+		assert.Equal(t, ErrInvalidDimensions, err, "for %v", dims)
+	}
+
+	for _, dims := range [][2]int{{1, 1}, {1, 80}, {24, 1}, {24, 80}} {
+		v, err := NewVT100Safe(dims[0], dims[1])
+		assert.Nil(t, err)
+		assert.Equal(t, dims[0], v.Height)
+		assert.Equal(t, dims[1], v.Width)
+	}
+
+	_, err := New(WithSize(0, 80))
+	assert.True(t, errors.Is(err, ErrInvalidDimensions))
+}
+
+func TestScrollback(t *testing.T) {
+	v, err := New(WithSize(2, 3), WithScrollback(2))
+	assert.Nil(t, err)
+
+	v.Write([]byte("a\r\nb\r\n" + esc("[1m") + "c\r\nd\r\ne"))
+	assert.Equal(t, splitLines("d  \ne  "), v.Content)
+	assert.Equal(t, 2, v.ScrollbackLen())
+
+	line, formats, err := v.ScrollbackLine(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "b  ", line)
+	assert.Equal(t, []Format{{}, {}, {}}, formats)
+
+	line, formats, err = v.ScrollbackLine(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "c  ", line)
+	assert.Equal(t, Bold, formats[0].Intensity)
+
+	_, _, err = v.ScrollbackLine(2)
+	assert.NotNil(t, err)
+
+	// By default, nothing is kept.
+	v = NewVT100(1, 1)
+	v.Write([]byte("a\r\nb"))
+	assert.Equal(t, 0, v.ScrollbackLen())
+}
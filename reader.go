@@ -0,0 +1,123 @@
+package vt100
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// ProcessReader decodes commands from r until it reaches EOF, calling
+// v.Process with each of them in turn.
+//
+// UnsupportedErrors are skipped. The first other error from Process, or
+// from decoding, is returned once all of r has been processed, except for
+// read errors, which stop it straight away. If r ends partway through a
+// command, io.ErrUnexpectedEOF is returned.
+func ProcessReader(v *VT100, r io.Reader) error {
+	return ProcessReaderContext(context.Background(), v, r)
+}
+
+// DecodeAll decodes commands from r until it reaches EOF, returning all of
+// them. They can be filtered or changed before being passed to Process.
+//
+// The first error from decoding is returned along with the commands, once
+// all of r has been decoded, except for read errors, which stop it straight
+// away. If r ends partway through a command, io.ErrUnexpectedEOF is
+// returned.
+//
+// Sequences are limited as they are for a new VT100, by DefaultMaxOSCLength
+// and DefaultMaxParams.
+func DecodeAll(r io.Reader) ([]Command, error) {
+	p := Parser{MaxStringLength: DefaultMaxOSCLength, MaxParams: DefaultMaxParams}
+	var cmds []Command
+	var first error
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		perr := p.Parse(buf[:n], func(cmd Command) {
+			cmds = append(cmds, cmd)
+		})
+		if perr != nil && first == nil {
+			first = perr
+		}
+
+		switch {
+		case err == io.EOF:
+			if !p.ground() && first == nil {
+				first = io.ErrUnexpectedEOF
+			}
+			return cmds, first
+		case err != nil:
+			return cmds, err
+		}
+	}
+}
+
+// ProcessReaderContext is like ProcessReader, but stops early with ctx's
+// error once ctx is done. A read that's in progress can't be interrupted, so
+// it's left to finish in the background, and what it reads is discarded.
+func ProcessReaderContext(ctx context.Context, v *VT100, r io.Reader) error {
+	type chunk struct {
+		data []byte
+		err  error
+	}
+	chunks := make(chan chunk)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			buf := make([]byte, 4096)
+			n, err := r.Read(buf)
+			select {
+			case chunks <- chunk{buf[:n], err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var p Parser
+	v.mut.RLock()
+	v.syncParser(&p)
+	v.mut.RUnlock()
+
+	var first error
+	keep := func(err error) {
+		var ue UnsupportedError
+		if err != nil && first == nil && !errors.As(err, &ue) {
+			first = err
+		}
+	}
+	for {
+		var c chunk
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case c = <-chunks:
+		}
+
+		keep(p.Parse(c.data, func(cmd Command) {
+			keep(v.Process(cmd))
+
+			// The command may have changed the mode that the rest are
+			// decoded in.
+			v.mut.RLock()
+			v.syncParser(&p)
+			v.mut.RUnlock()
+		}))
+
+		switch {
+		case c.err == io.EOF:
+			if !p.ground() {
+				keep(io.ErrUnexpectedEOF)
+			}
+			return first
+		case c.err != nil:
+			return c.err
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package vt100_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+func TestProcessReader(t *testing.T) {
+	pr, pw := io.Pipe()
+	go func() {
+		// Split partway through a sequence.
+		pw.Write([]byte("ab" + esc("[1")))
+		pw.Write([]byte("mc" + esc("[5;6y") + "\r\nd"))
+		pw.Close()
+	}()
+
+	v := NewVT100(2, 4)
+	assert.Nil(t, ProcessReader(v, pr))
+	assert.Equal(t, splitLines("abc \nd   "), v.Content)
+	assert.Equal(t, Bold, v.Format[0][2].Intensity)
+
+	// Other errors are returned, once everything's been processed.
+	pr, pw = io.Pipe()
+	go func() {
+		pw.Write([]byte(esc("[9;9H") + "x" + esc("[")))
+		pw.Close()
+	}()
+	v = NewVT100(2, 4)
+	err := ProcessReader(v, pr)
+	assert.Contains(t, err.Error(), "out of bounds")
+	assert.Equal(t, 'x', v.Content[1][3])
+
+	pr, pw = io.Pipe()
+	go func() {
+		pw.Write([]byte("x" + esc("[")))
+		pw.Close()
+	}()
+	assert.Equal(t, io.ErrUnexpectedEOF, ProcessReader(NewVT100(1, 1), pr))
+
+	// The terminal's limits apply.
+	v = NewVT100(1, 4)
+	v.MaxOSCLength = 4
+	err = ProcessReader(v, strings.NewReader(esc("]0;title\a")+"x"))
+	assert.Contains(t, err.Error(), "longer than 4 bytes")
+	assert.Equal(t, "x   ", string(v.Content[0]))
+}
+
+func TestDecodeAll(t *testing.T) {
+	cmds, err := DecodeAll(strings.NewReader("ab" + esc("[1m") + "c" + esc("]0;title\a") + "\r\n" + esc("[5;6y")))
+	assert.Nil(t, err)
+	var names []string
+	for _, cmd := range cmds {
+		names = append(names, cmd.String())
+	}
+	assert.Equal(t, []string{
+		"RUNE('a')", "RUNE('b')", "SGR[1]", "RUNE('c')", `OSC("0;title")`,
+		"CTRL(CR)", "CTRL(LF)", `ESC("5;6" 'y')`,
+	}, names)
+
+	// The survivors of a filter can be processed.
+	v := NewVT100(2, 4)
+	for _, cmd := range cmds {
+		if cmd.String() != "SGR[1]" {
+			v.Process(cmd)
+		}
+	}
+	assert.Equal(t, splitLines("abc \n    "), v.Content)
+	assert.Equal(t, Normal, v.Format[0][2].Intensity)
+
+	cmds, err = DecodeAll(strings.NewReader("x" + esc("[")))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Len(t, cmds, 1)
+
+	// Sequences are limited as they are for a new VT100.
+	cmds, err = DecodeAll(strings.NewReader(esc("]0;"+strings.Repeat("x", DefaultMaxOSCLength)+"\a") +
+		esc("["+strings.Repeat("1;", 2*DefaultMaxParams)+"m")))
+	assert.Contains(t, err.Error(), "longer than")
+	if assert.Len(t, cmds, 1) {
+		assert.Equal(t, "SGR["+strings.Repeat("1;", DefaultMaxParams-1)+"1]", cmds[0].String())
+	}
+}
+
+func TestProcessReaderContext(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	v := NewVT100(1, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- ProcessReaderContext(ctx, v, pr)
+	}()
+
+	pw.Write([]byte("ab"))
+	wait, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	assert.Nil(t, v.WaitForText(wait, "ab"))
+
+	// The reader never ends, but cancelling stops it all the same.
+	cancel()
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("not stopped by cancellation")
+	}
+	assert.Equal(t, "ab  ", string(v.Content[0]))
+}
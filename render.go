@@ -0,0 +1,61 @@
+package vt100
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/muesli/termenv"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// RenderImage draws the terminal as an image, with each cell as wide as
+// face's advance for 'M' and as tall as its line height. Colors are applied
+// as in HTML, and the intensity of text sets its opacity over the
+// background. It's meant for screenshots of the terminal, e.g. as artifacts
+// of a CI run; basicfont.Face7x13 from golang.org/x/image suits it well.
+func (v *VT100) RenderImage(face font.Face) *image.RGBA {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	metrics := face.Metrics()
+	advance, _ := face.GlyphAdvance('M')
+	cellW, cellH := advance.Ceil(), metrics.Height.Ceil()
+	img := image.NewRGBA(image.Rect(0, 0, v.Width*cellW, v.Height*cellH))
+
+	defFg, defBg := v.defaultColors()
+	d := font.Drawer{Dst: img, Face: face}
+	for y, row := range v.Content {
+		for x, r := range row {
+			f := v.Format[y][x]
+			fg, bg := f.Fg, f.Bg
+			if fg == nil {
+				fg = defFg
+			}
+			if bg == nil {
+				bg = defBg
+			}
+			if f.Reverse {
+				fg, bg = bg, fg
+			}
+
+			cell := image.Rect(x*cellW, y*cellH, (x+1)*cellW, (y+1)*cellH)
+			draw.Draw(img, cell, image.NewUniform(toRGBA(bg, 255)), image.Point{}, draw.Src)
+			if r == ' ' || f.Conceal {
+				continue
+			}
+
+			d.Src = image.NewUniform(toRGBA(fg, f.Intensity.alpha()))
+			d.Dot = fixed.P(cell.Min.X, cell.Min.Y+metrics.Ascent.Ceil())
+			d.DrawString(string(r))
+		}
+	}
+	return img
+}
+
+// toRGBA converts c to a color with the given opacity.
+func toRGBA(c termenv.Color, alpha uint8) color.Color {
+	r, g, b := termenv.ConvertToRGB(c).RGB255()
+	return color.NRGBA{R: r, G: g, B: b, A: alpha}
+}
@@ -0,0 +1,69 @@
+package vt100_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+	"github.com/vito/vt100/vttest"
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestRenderImage(t *testing.T) {
+	v := NewVT100(2, 2)
+	v.Write([]byte(esc("[31;42m") + "a" + esc("[0;1;34m") + "b" +
+		esc("[0;7m") + "c" + esc("[0;2;38;2;255;128;0m") + "d"))
+
+	img := v.RenderImage(basicfont.Face7x13)
+	assert.Equal(t, image.Rect(0, 0, 14, 26), img.Bounds())
+
+	// The corners of each cell are background.
+	assert.Equal(t, color.RGBA{0, 128, 0, 255}, img.At(0, 0))
+	assert.Equal(t, color.RGBA{0, 0, 0, 255}, img.At(7, 0))
+	assert.Equal(t, color.RGBA{170, 170, 170, 255}, img.At(0, 13))
+	assert.Equal(t, color.RGBA{0, 0, 0, 255}, img.At(13, 25))
+
+	path := filepath.Join("testdata", "render.golden.png")
+	if os.Getenv(vttest.UpdateGoldenEnv) == "1" {
+		var buf bytes.Buffer
+		assert.Nil(t, png.Encode(&buf, img))
+		assert.Nil(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+		return
+	}
+
+	f, err := os.Open(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+	decoded, err := png.Decode(f)
+	if !assert.Nil(t, err) {
+		return
+	}
+	golden := image.NewRGBA(decoded.Bounds())
+	draw.Draw(golden, golden.Bounds(), decoded, image.Point{}, draw.Src)
+	assert.Equal(t, golden.Pix, img.Pix, "image differs from %s", path)
+}
+
+func TestOutOfRangeColors(t *testing.T) {
+	for _, seq := range []string{"[38;5;300m", "[48;5;-1m", "[38;5;-3m", "[38;2;999;0;0m", "[58:2::0:-1:0m"} {
+		v := NewVT100(1, 2)
+		v.Write([]byte(esc("[31m") + "a"))
+		assert.NotNil(t, v.Process(cmd(esc(seq))), seq)
+		v.Write([]byte("b"))
+
+		// The color is ignored, and rendering doesn't panic.
+		assert.Equal(t, Format{Fg: termenv.ANSIRed}, v.Format[0][1], seq)
+		assert.NotPanics(t, func() { v.HTML() }, seq)
+		assert.NotPanics(t, func() { v.RenderImage(basicfont.Face7x13) }, seq)
+	}
+}
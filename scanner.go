@@ -1,10 +1,11 @@
 package vt100
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Decode decodes one ANSI terminal command from s.
@@ -17,27 +18,70 @@ import (
 // it to VT100.Process().
 //
 // You should not share s with any other reader, because it could leave
-// the stream in an invalid state.
+// the stream in an invalid state. Decode returns io.EOF if s is exhausted
+// between commands, and io.ErrUnexpectedEOF if it runs out partway through
+// one, in which case what was read of it is lost; use a Parser to decode a
+// stream that may be split at any point.
 func Decode(s io.RuneScanner) (Command, error) {
-	r, size, err := s.ReadRune()
-	if err != nil {
-		return nil, err
-	}
+	return decode(s, &Parser{})
+}
 
-	if r == unicode.ReplacementChar && size == 1 {
-		return nil, fmt.Errorf("non-utf8 data from reader")
-	}
+// Decode is like the package's Decode, but decodes the command as v would if
+// it was written to it: with v's limits on sequences, and as a VT52 sequence
+// in VT52Mode. Each command should be processed by v before the next is
+// decoded, since it may change the mode.
+func (v *VT100) Decode(s io.RuneScanner) (Command, error) {
+	var p Parser
+	v.mut.RLock()
+	v.syncParser(&p)
+	v.mut.RUnlock()
+	return decode(s, &p)
+}
+
+// decode decodes one command from s with p, which must be between commands.
+func decode(s io.RuneScanner, p *Parser) (Command, error) {
+	for read := false; ; read = true {
+		r, size, err := s.ReadRune()
+		if err == io.EOF && read {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if r == unicode.ReplacementChar && size == 1 {
+			return nil, fmt.Errorf("non-utf8 data from reader")
+		}
 
-	if r == escape || r == monogramCsi { // At beginning of escape sequence.
-		s.UnreadRune()
-		return scanEscapeCommand(s)
+		if cmd, ok := p.next(r); ok {
+			return cmd, nil
+		}
 	}
+}
+
+// ErrIncomplete is returned by DecodeBytes when its input ends partway
+// through a command.
+var ErrIncomplete = errors.New("incomplete command")
 
-	if unicode.IsControl(r) {
-		return controlCommand(r), nil
+// DecodeBytes decodes the first ANSI terminal command in p, returning it along
+// with the number of bytes of p that it took up. If p ends before the command
+// does, DecodeBytes returns ErrIncomplete with n == 0; it should be called
+// again once more data is available. io.EOF is returned if p is empty.
+//
+// Invalid UTF-8 is decoded as utf8.RuneError, and reported as an error
+// alongside the command it's part of, so that the caller can tell what
+// happened but carry on past it.
+func DecodeBytes(p []byte) (cmd Command, n int, err error) {
+	if len(p) == 0 {
+		return nil, 0, io.EOF
 	}
 
-	return runeCommand(r), nil
+	var parser Parser
+	cmd, n, err = parser.step(p)
+	if cmd == nil && err == nil {
+		return nil, 0, ErrIncomplete
+	}
+	return cmd, n, err
 }
 
 const (
@@ -46,52 +90,414 @@ const (
 	// to putting "\u001b[".
 	escape      = '\u001b'
 	monogramCsi = '\u009b'
+
+	// cancel and substitute abort an escape sequence.
+	cancel     = '\u0018'
+	substitute = '\u001a'
 )
 
-var (
-	csEnd = &unicode.RangeTable{R16: []unicode.Range16{{Lo: 64, Hi: 126, Stride: 1}}}
+// parserState is the position of a Parser within an escape sequence.
+type parserState int
+
+const (
+	// Not in an escape sequence.
+	parseGround parserState = iota
+	// After ESC.
+	parseEscape
+	// After one or more intermediate bytes of an ESC sequence, e.g. "ESC (".
+	parseEscapeIntermediate
+	// After "ESC [" or the single-rune CSI, in the arguments.
+	parseCSI
+	// Within the payload of an OSC, DCS, SOS, PM or APC sequence.
+	parseString
+	// After an ESC within such a payload, possibly starting the ST.
+	parseStringEscape
+	// After "ESC Y" in VT52 mode, in the row and column.
+	parseVT52Address
 )
 
-// scanEscapeCommand scans to the end of the current escape sequence. The scanner
-// must be positioned at an escape rune (esc or the unicode CSI).
-func scanEscapeCommand(s io.RuneScanner) (Command, error) {
-	csi := false
-	esc, _, err := s.ReadRune()
-	if err != nil {
-		return nil, err
+// Parser incrementally decodes ANSI terminal commands from a stream of bytes.
+// Unlike Decode, it remembers where it left off between calls, so a command
+// or rune may be split across any number of them without anything being
+// lost.
+//
+// The zero value is a Parser ready to use.
+type Parser struct {
+	// MaxStringLength is the most bytes of data that a control string, such
+	// as an OSC sequence, may carry. Longer ones are discarded, and reported
+	// as an error once they end. Zero or less means there's no limit.
+	MaxStringLength int
+
+	// MaxParams is the most parameters, counting sub-parameters, that a CSI
+	// sequence may have. Any more are ignored. Zero or less means there's no
+	// limit.
+	MaxParams int
+
+	// VT52Mode makes p decode escape sequences as a VT52 would: ESC and a
+	// single rune, or "ESC Y" and two more for the cursor address. CSI and
+	// control strings aren't recognized. A VT100 sets it as it enters and
+	// leaves VT52 mode.
+	VT52Mode bool
+
+	state parserState
+
+	// kind is the first intermediate of an ESC sequence, or the rune that
+	// introduced a control string.
+	kind rune
+	// args holds the arguments of a CSI sequence, the final intermediates of
+	// an ESC sequence, or the payload of a control string.
+	args []byte
+	// quote is set within a quoted CSI argument.
+	quote bool
+	// params is the number of separators in a CSI sequence so far, and
+	// paramLen is the length of its current parameter.
+	params, paramLen int
+	// overflow is set once a control string is longer than MaxStringLength.
+	overflow bool
+	// err is an error found by next, to be returned by step.
+	err error
+	// raw holds the bytes of the command being parsed by step, up to
+	// maxRawLength of them. runeStart is where the rune being parsed starts
+	// in it, and restart, if positive, is where a new sequence started that
+	// interrupted the previous one.
+	raw                []byte
+	runeStart, restart int
+
+	// partial holds the start of a multi-byte rune that was cut off at the
+	// end of the last call.
+	partial    [utf8.UTFMax]byte
+	partialLen int
+}
+
+// Parse decodes the commands in data, calling fn with each one in turn.
+// Commands and runes which are cut off at the end of data are completed by
+// later calls.
+//
+// Invalid UTF-8 is decoded as utf8.RuneError. Once all of data has been
+// parsed, the first such error is returned.
+func (p *Parser) Parse(data []byte, fn func(Command)) error {
+	var first error
+	for len(data) > 0 {
+		cmd, n, err := p.step(data)
+		if err != nil && first == nil {
+			first = err
+		}
+		if cmd != nil {
+			fn(cmd)
+		}
+		data = data[n:]
 	}
-	if esc != escape && esc != monogramCsi {
-		return nil, fmt.Errorf("invalid content")
+	return first
+}
+
+// Feed advances p by the single byte b. It returns the command that b
+// completes and true, or false if more input is needed before there is one.
+//
+// Invalid UTF-8 is decoded as utf8.RuneError, which is returned as a command
+// along with an error saying so. Other errors, such as for a control string
+// that was too long, come without a command.
+func (p *Parser) Feed(b byte) (Command, bool, error) {
+	cmd, _, err := p.step([]byte{b})
+	return cmd, cmd != nil, err
+}
+
+// step parses data up to the end of the first command in it, returning the
+// command and the number of bytes it took. If data ends before a command is
+// complete, step consumes all of it and returns a nil Command. A sequence
+// that's discarded is reported as an error with a nil Command, as soon as
+// it's over.
+func (p *Parser) step(data []byte) (Command, int, error) {
+	var err error
+	n := 0
+	for n < len(data) {
+		if p.state == parseGround {
+			p.raw = p.raw[:0]
+		} else if p.restart > 0 {
+			p.raw = append(p.raw[:0], p.raw[p.restart:]...)
+		}
+		p.restart = 0
+		p.runeStart = len(p.raw)
+
+		var r rune
+		var size int
+		if p.partialLen > 0 {
+			// Finish the rune that was cut off by the last call.
+			buf := p.partial[:p.partialLen+copy(p.partial[p.partialLen:], data[n:])]
+			if !utf8.FullRune(buf) {
+				p.partialLen = len(buf)
+				return nil, len(data), err
+			}
+
+			r, size = utf8.DecodeRune(buf)
+			if r == utf8.RuneError && size == 1 {
+				size = invalidLen(buf)
+				err = fmt.Errorf("non-utf8 data %q", buf[:size])
+			}
+			p.appendRaw(buf[:size])
+			size -= p.partialLen
+			p.partialLen = 0
+		} else {
+			r, size = utf8.DecodeRune(data[n:])
+			if r == utf8.RuneError && size == 1 {
+				if !utf8.FullRune(data[n:]) {
+					p.partialLen = copy(p.partial[:], data[n:])
+					return nil, len(data), err
+				}
+				size = invalidLen(data[n:])
+				err = fmt.Errorf("non-utf8 data %q", data[n:n+size])
+			}
+			p.appendRaw(data[n : n+size])
+		}
+
+		n += size
+		cmd, ok := p.next(r)
+		if p.err != nil {
+			if err == nil {
+				err = p.err
+			}
+			p.err = nil
+			if !ok {
+				return nil, n, err
+			}
+		}
+		if ok {
+			return cmd, n, err
+		}
 	}
-	if esc == monogramCsi {
-		csi = true
+	return nil, n, err
+}
+
+// maxRawLength is the most bytes of a command that a Parser keeps hold of for
+// error reporting.
+const maxRawLength = 256
+
+func (p *Parser) appendRaw(b []byte) {
+	if room := maxRawLength - len(p.raw); len(b) > room {
+		b = b[:room]
 	}
+	p.raw = append(p.raw, b...)
+}
 
-	var args bytes.Buffer
-	quote := false
-	for i := 0; ; i++ {
-		r, _, err := s.ReadRune()
-		if err != nil {
-			return nil, err
+// invalidLen returns the length of the invalid UTF-8 at the start of b. A
+// rune that was cut short counts as a single error, however much of it there
+// was, rather than one for each of its bytes.
+func invalidLen(b []byte) int {
+	n := 1
+	for n < len(b) && !utf8.FullRune(b[:n+1]) {
+		n++
+	}
+	return n
+}
+
+// ground reports whether p is between commands, and not partway through a
+// rune either.
+func (p *Parser) ground() bool {
+	return p.state == parseGround && p.partialLen == 0
+}
+
+// next advances p by the rune r, returning the command r completes, if any.
+func (p *Parser) next(r rune) (Command, bool) {
+	switch p.state {
+	case parseGround:
+		switch {
+		case r == escape:
+			p.begin(parseEscape)
+			return nil, false
+		case r == monogramCsi:
+			p.begin(parseCSI)
+			return nil, false
+		case unicode.IsControl(r):
+			return controlCommand(r), true
 		}
-		if i == 0 && r == '[' {
-			csi = true
-			continue
+		return runeCommand(r), true
+
+	case parseEscape:
+		if p.VT52Mode {
+			return p.nextVT52(r)
+		}
+		switch {
+		case r == '[':
+			p.state = parseCSI
+		case r == ']' || r == 'P' || r == 'X' || r == '^' || r == '_':
+			p.kind = r
+			p.state = parseString
+		case r >= 0x20 && r <= 0x2f:
+			p.kind = r
+			p.state = parseEscapeIntermediate
+		case r < 0x20:
+			return p.control(r)
+		default:
+			p.state = parseGround
+			return escapeCommand{r, ""}, true
+		}
+
+	case parseEscapeIntermediate:
+		switch {
+		case r >= 0x20 && r <= 0x2f:
+			p.appendArg(r)
+		case r < 0x20:
+			return p.control(r)
+		default:
+			p.appendArg(r)
+			p.state = parseGround
+			return escapeCommand{p.kind, string(p.args)}, true
+		}
+
+	case parseCSI:
+		switch {
+		case r < 0x20:
+			return p.control(r)
+		case r == '"' && (p.quote || p.quoteable()):
+			p.quote = !p.quote
+			p.appendParam(r)
+		case !p.quote && r >= 0x40 && r <= 0x7e:
+			p.state = parseGround
+			return escapeCommand{r, string(p.args)}, true
+		case r == 0x7f:
+			// DEL is ignored everywhere.
+		default:
+			p.appendParam(r)
 		}
 
-		if !csi {
-			return escapeCommand{r, ""}, nil
-		} else if quote == false && unicode.Is(csEnd, r) {
-			return escapeCommand{r, args.String()}, nil
+	case parseString:
+		switch r {
+		case '\a':
+			return p.endString()
+		case escape:
+			p.state = parseStringEscape
+		case cancel, substitute:
+			p.state = parseGround
+		default:
+			if p.overflow {
+				break
+			}
+			p.appendArg(r)
+			if p.MaxStringLength > 0 && len(p.args) > p.MaxStringLength {
+				p.overflow = true
+				p.args = p.args[:0]
+			}
 		}
 
-		if r == '"' {
-			quote = !quote
+	case parseVT52Address:
+		if r < 0x20 {
+			return p.control(r)
+		}
+		p.appendArg(r)
+		if utf8.RuneCount(p.args) == 2 {
+			p.state = parseGround
+			return vt52Command{'Y', string(p.args)}, true
 		}
 
-		// Otherwise, we're still in the args, and this rune is one of those args.
-		if _, err := args.WriteRune(r); err != nil {
-			panic(err) // WriteRune cannot return an error from bytes.Buffer.
+	case parseStringEscape:
+		if r == '\\' {
+			return p.endString()
 		}
+
+		// An ESC that doesn't begin the ST abandons the string, and begins a
+		// new sequence of its own.
+		p.err = p.stringError("unterminated")
+		p.begin(parseEscape)
+		p.restart = p.runeStart - 1
+		return p.next(r)
+	}
+	return nil, false
+}
+
+// nextVT52 advances p by the rune r following an ESC in VT52 mode.
+func (p *Parser) nextVT52(r rune) (Command, bool) {
+	switch {
+	case r < 0x20:
+		return p.control(r)
+	case r == 'Y':
+		p.state = parseVT52Address
+		return nil, false
+	}
+	p.state = parseGround
+	return vt52Command{r, ""}, true
+}
+
+// endString completes a control string.
+func (p *Parser) endString() (Command, bool) {
+	p.state = parseGround
+	if p.overflow {
+		p.err = p.stringError(fmt.Sprintf("longer than %d bytes", p.MaxStringLength))
+		return nil, false
+	}
+	return stringCommand{p.kind, string(p.args)}, true
+}
+
+// stringError returns an error describing why the control string that p is
+// in was discarded.
+func (p *Parser) stringError(why string) error {
+	return fmt.Errorf("discarded %s control string %q", why, string(p.kind)+string(p.args))
+}
+
+// control handles the C0 control character r in the middle of a sequence.
+// ESC begins a new sequence and CAN and SUB abandon the current one, but
+// anything else is carried out without interrupting it.
+func (p *Parser) control(r rune) (Command, bool) {
+	switch r {
+	case escape:
+		p.begin(parseEscape)
+		p.restart = p.runeStart
+		return nil, false
+	case cancel, substitute:
+		p.state = parseGround
+		return nil, false
+	}
+	return controlCommand(r), true
+}
+
+// begin starts a new sequence in state s.
+func (p *Parser) begin(s parserState) {
+	p.state = s
+	p.args = p.args[:0]
+	p.quote = false
+	p.params, p.paramLen = 0, 0
+	p.overflow = false
+}
+
+// quoteable reports whether a '"' in a CSI sequence opens a quoted argument,
+// which it does at the start of any but the first one. Elsewhere it's an
+// intermediate byte, as in DECSCA.
+func (p *Parser) quoteable() bool {
+	return len(p.args) > 0 && p.args[len(p.args)-1] == ';'
+}
+
+// maxParamLength is the most bytes kept of any one parameter of a CSI
+// sequence, not counting leading zeros. It's plenty for any number that fits
+// in an int.
+const maxParamLength = 32
+
+// appendParam adds r to the arguments of a CSI sequence, unless MaxParams or
+// maxParamLength says that it should be ignored.
+func (p *Parser) appendParam(r rune) {
+	isParam := r >= 0x30 && r <= 0x3f
+	switch {
+	case isParam && p.MaxParams > 0 && p.params >= p.MaxParams-1 && (r == ';' || r == ':'):
+		p.params = p.MaxParams
+		return
+	case isParam && p.MaxParams > 0 && p.params >= p.MaxParams:
+		return
+	case r == ';' || r == ':':
+		p.params++
+		p.paramLen = 0
+	case r == '0' && p.paramLen == 1 && p.args[len(p.args)-1] == '0':
+		// Leading zeros don't change the value.
+		return
+	case p.paramLen >= maxParamLength:
+		return
+	default:
+		p.paramLen++
+	}
+	p.appendArg(r)
+}
+
+func (p *Parser) appendArg(r rune) {
+	if r < utf8.RuneSelf {
+		p.args = append(p.args, byte(r))
+		return
 	}
+	var b [utf8.UTFMax]byte
+	p.args = append(p.args, b[:utf8.EncodeRune(b[:], r)]...)
 }
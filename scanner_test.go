@@ -4,6 +4,7 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -33,6 +34,10 @@ nextCase:
 		{"\u001b[12;\"asd\"s", []Command{
 			escapeCommand{'s', `12;"asd"`},
 		}},
+		{"\u001b[1\"q\u001b[\"q", []Command{
+			escapeCommand{'q', `1"`},
+			escapeCommand{'q', `"`},
+		}},
 	} {
 		s := strings.NewReader(testCase.in)
 
@@ -53,3 +58,244 @@ nextCase:
 		assert.Equal(t, err, io.EOF)
 	}
 }
+
+func TestParser(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []Command
+	}{
+		{"fÜ\r\n", []Command{
+			runeCommand('f'),
+			runeCommand('Ü'),
+			controlCommand('\r'),
+			controlCommand('\n'),
+		}},
+		{"\u001b[123;31d\u009b?25l", []Command{
+			escapeCommand{'d', "123;31"},
+			escapeCommand{'l', "?25"},
+		}},
+		{"\u001b7\u001b(B\u001b#8", []Command{
+			escapeCommand{'7', ""},
+			escapeCommand{'(', "B"},
+			escapeCommand{'#', "8"},
+		}},
+		{"\u001b]0;a window title that is rather long\a.", []Command{
+			stringCommand{']', "0;a window title that is rather long"},
+			runeCommand('.'),
+		}},
+		{"\u001b]8;;http://example.com/ü\u001b\\x", []Command{
+			stringCommand{']', "8;;http://example.com/ü"},
+			runeCommand('x'),
+		}},
+		{"\u001bPq#0\u001b\\", []Command{
+			stringCommand{'P', "q#0"},
+		}},
+		{"\u001b[12;\"asd\"s", []Command{
+			escapeCommand{'s', `12;"asd"`},
+		}},
+		// Control characters are carried out in the middle of a sequence.
+		{"\u001b[1\n2H", []Command{
+			controlCommand('\n'),
+			escapeCommand{'H', "12"},
+		}},
+		// ESC starts a new sequence, and CAN abandons one.
+		{"\u001b[1\u001b[2J\u001b[3\u0018x", []Command{
+			escapeCommand{'J', "2"},
+			runeCommand('x'),
+		}},
+		{"\u001b]0;abandoned\u001b[1m", []Command{
+			escapeCommand{'m', "1"},
+		}},
+		{"😀✓", []Command{
+			runeCommand('😀'),
+			runeCommand('✓'),
+		}},
+		{"a\xffb\xe2\x9cc", []Command{
+			runeCommand('a'),
+			runeCommand(utf8.RuneError),
+			runeCommand('b'),
+			runeCommand(utf8.RuneError),
+			runeCommand('c'),
+		}},
+	} {
+		parse := func(chunks ...string) []Command {
+			var p Parser
+			var got []Command
+			for _, c := range chunks {
+				p.Parse([]byte(c), func(cmd Command) {
+					got = append(got, cmd)
+				})
+			}
+			return got
+		}
+
+		assert.Equal(t, tc.want, parse(tc.in), "while parsing %q", tc.in)
+
+		// Split the input at every possible point.
+		for i := 0; i <= len(tc.in); i++ {
+			assert.Equal(t, tc.want, parse(tc.in[:i], tc.in[i:]), "while parsing %q split at %d", tc.in, i)
+		}
+
+		// And a byte at a time.
+		var bytes []string
+		for i := 0; i < len(tc.in); i++ {
+			bytes = append(bytes, tc.in[i:i+1])
+		}
+		assert.Equal(t, tc.want, parse(bytes...), "while parsing %q a byte at a time", tc.in)
+	}
+}
+
+func TestParserInvalidUTF8(t *testing.T) {
+	var p Parser
+	var got []Command
+	record := func(cmd Command) {
+		got = append(got, cmd)
+	}
+
+	assert.Nil(t, p.Parse([]byte("a\xe2\x9c"), record))
+	assert.NotNil(t, p.Parse([]byte("b"), record))
+	assert.Equal(t, []Command{
+		runeCommand('a'),
+		runeCommand(utf8.RuneError),
+		runeCommand('b'),
+	}, got)
+
+	assert.NotNil(t, p.Parse([]byte("\xff"), record))
+}
+
+func TestParserFeed(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Command
+	}{
+		{"\u001b[?1;2:3;\"a;b\"h", escapeCommand{'h', `?1;2:3;"a;b"`}},
+		{"\u001b]8;;http://example.com/ü\u001b\\", stringCommand{']', "8;;http://example.com/ü"}},
+		{"\u001b[38;2;1;2;3\r", controlCommand('\r')},
+		{"😀", runeCommand('😀')},
+	} {
+		var p Parser
+		for i := 0; i < len(tc.in)-1; i++ {
+			cmd, ok, err := p.Feed(tc.in[i])
+			assert.Nil(t, cmd)
+			assert.False(t, ok, "while feeding byte %d of %q", i, tc.in)
+			assert.Nil(t, err)
+		}
+		cmd, ok, err := p.Feed(tc.in[len(tc.in)-1])
+		assert.Equal(t, tc.want, cmd)
+		assert.True(t, ok, "while feeding the last byte of %q", tc.in)
+		assert.Nil(t, err)
+	}
+
+	p := Parser{MaxStringLength: 1}
+	for _, b := range []byte("\u001b]0;title") {
+		p.Feed(b)
+	}
+	cmd, ok, err := p.Feed('\a')
+	assert.Nil(t, cmd)
+	assert.False(t, ok)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeBytes(t *testing.T) {
+	stream := []byte("a\u001b[1;31mü\u001b]0;title\a\u001b(0q\r\n\xff😀\u009b2J")
+
+	var p Parser
+	var want []Command
+	p.Parse(stream, func(cmd Command) {
+		want = append(want, cmd)
+	})
+
+	var got []Command
+	var total int
+	for rest := stream; len(rest) > 0; {
+		cmd, n, _ := DecodeBytes(rest)
+		if !assert.NotNil(t, cmd, "while decoding %q", rest) {
+			return
+		}
+		got = append(got, cmd)
+		total += n
+		rest = rest[n:]
+	}
+	assert.Equal(t, want, got)
+	assert.Equal(t, len(stream), total)
+
+	_, _, err := DecodeBytes(nil)
+	assert.Equal(t, io.EOF, err)
+
+	for _, in := range []string{"\u001b", "\u001b[", "\u001b[1;3", "\u001b(", "\u001b]0;title", "\u001b]0;title\u001b", "\xe2\x9c"} {
+		cmd, n, err := DecodeBytes([]byte(in))
+		assert.Nil(t, cmd)
+		assert.Equal(t, 0, n)
+		assert.Equal(t, ErrIncomplete, err, "while decoding %q", in)
+	}
+
+	cmd, n, err := DecodeBytes([]byte("\xffa"))
+	assert.Equal(t, runeCommand(utf8.RuneError), cmd)
+	assert.Equal(t, 1, n)
+	assert.NotNil(t, err)
+}
+
+func TestParserMaxStringLength(t *testing.T) {
+	p := Parser{MaxStringLength: 8}
+	var got []Command
+	record := func(cmd Command) {
+		got = append(got, cmd)
+	}
+
+	assert.Nil(t, p.Parse([]byte("\u001b]0;123456\a"), record))
+	assert.Nil(t, p.Parse([]byte("\u001b]0;1234567"), record))
+	assert.Equal(t, []Command{stringCommand{']', "0;123456"}}, got)
+
+	// The rest of the string is discarded too, but not what follows it.
+	assert.NotNil(t, p.Parse([]byte(strings.Repeat("x", 10000)+"\u001b\\a"), record))
+	assert.Equal(t, []Command{stringCommand{']', "0;123456"}, runeCommand('a')}, got)
+	assert.True(t, cap(p.args) < 100)
+}
+
+func TestParserVT52Mode(t *testing.T) {
+	p := Parser{VT52Mode: true}
+	var got []Command
+	assert.Nil(t, p.Parse([]byte("\u001bAa\u001bY!\r#\u001b[\u001b<"), func(cmd Command) {
+		got = append(got, cmd)
+	}))
+	assert.Equal(t, []Command{
+		vt52Command{'A', ""},
+		runeCommand('a'),
+		controlCommand('\r'),
+		vt52Command{'Y', "!#"},
+		vt52Command{'[', ""},
+		vt52Command{'<', ""},
+	}, got)
+	assert.Equal(t, "VT52(CUU)", got[0].String())
+	assert.Equal(t, "VT52(DCA(2,4))", got[3].String())
+	assert.Equal(t, "VT52('[')", got[4].String())
+}
+
+func TestParserMaxParams(t *testing.T) {
+	p := Parser{MaxParams: 3}
+	var got []Command
+	p.Parse([]byte("\u001b[1;2:3;4;5 q\u001b[?1;2;3;4h\u001b[0000005A\u001b["+strings.Repeat("9", 100)+"B"), func(cmd Command) {
+		got = append(got, cmd)
+	})
+	assert.Equal(t, []Command{
+		escapeCommand{'q', "1;2:3 "},
+		escapeCommand{'h', "?1;2;3"},
+		escapeCommand{'A', "05"},
+		escapeCommand{'B', strings.Repeat("9", maxParamLength)},
+	}, got)
+}
+
+func TestDecodeEOF(t *testing.T) {
+	for _, in := range []string{"\u001b", "\u001b[", "\u001b[1;2", "\u001b(", "\u001b]0;tit", "\u001b]0;title\u001b"} {
+		_, err := Decode(strings.NewReader(in))
+		assert.Equal(t, io.ErrUnexpectedEOF, err, "while decoding %q", in)
+	}
+
+	s := strings.NewReader("a\u001b[m")
+	_, err := Decode(s)
+	assert.Nil(t, err)
+	_, err = Decode(s)
+	assert.Nil(t, err)
+	_, err = Decode(s)
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,176 @@
+package vt100
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Position is a 0-indexed cell coordinate on the terminal.
+type Position struct {
+	Y, X int
+}
+
+// Find returns the position of the first occurrence of s on the screen,
+// scanning rows from top to bottom. Matches do not span rows, and blank cells
+// match ' ' literally.
+func (v *VT100) Find(s string) (y, x int, ok bool) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	ps := v.findAll([]rune(s), false, 1)
+	if len(ps) == 0 {
+		return 0, 0, false
+	}
+	return ps[0].Y, ps[0].X, true
+}
+
+// FindAll returns the positions of all non-overlapping occurrences of s on
+// the screen, in reading order.
+func (v *VT100) FindAll(s string) []Position {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	return v.findAll([]rune(s), false, -1)
+}
+
+// FindFold is like Find, but matches case-insensitively.
+func (v *VT100) FindFold(s string) (y, x int, ok bool) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	ps := v.findAll([]rune(s), true, 1)
+	if len(ps) == 0 {
+		return 0, 0, false
+	}
+	return ps[0].Y, ps[0].X, true
+}
+
+// FindAllFold is like FindAll, but matches case-insensitively.
+func (v *VT100) FindAllFold(s string) []Position {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	return v.findAll([]rune(s), true, -1)
+}
+
+// findAll returns up to n matches of needle, or all of them if n < 0.
+func (v *VT100) findAll(needle []rune, fold bool, n int) []Position {
+	if len(needle) == 0 {
+		return nil
+	}
+
+	var ps []Position
+	for y, row := range v.Content {
+		for x := 0; x+len(needle) <= len(row); x++ {
+			if !runesEqual(row[x:x+len(needle)], needle, fold) {
+				continue
+			}
+			ps = append(ps, Position{Y: y, X: x})
+			if len(ps) == n {
+				return ps
+			}
+			x += len(needle) - 1
+		}
+	}
+	return ps
+}
+
+func runesEqual(a, b []rune, fold bool) bool {
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		if !fold || unicode.ToLower(a[i]) != unicode.ToLower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Match is a regular expression match against the text on the screen.
+type Match struct {
+	// Start is the position of the first cell of the match, and End is the
+	// position just past its last cell. End is on a later row than Start
+	// only when matching across wrapped lines.
+	Start, End Position
+
+	// Submatches holds the text of the whole match followed by the text of
+	// each parenthesized subexpression, as with
+	// regexp.Regexp.FindStringSubmatch.
+	Submatches []string
+}
+
+// Match returns every match of re against the rows of the screen. Each row is
+// matched on its own, with its trailing spaces trimmed.
+func (v *VT100) Match(re *regexp.Regexp) []Match {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	var ms []Match
+	for y := range v.Content {
+		ms = append(ms, v.matchRows(re, y, y)...)
+	}
+	return ms
+}
+
+// MatchWrapped is like Match, but rows that were automatically wrapped are
+// joined with the rows they wrapped onto before matching, so that text which
+// didn't fit on one row can still be matched.
+func (v *VT100) MatchWrapped(re *regexp.Regexp) []Match {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	var ms []Match
+	for y := 0; y < v.Height; y++ {
+		end := y
+		for end < v.Height-1 && v.LineWrapped[end+1] {
+			end++
+		}
+		ms = append(ms, v.matchRows(re, y, end)...)
+		y = end
+	}
+	return ms
+}
+
+// matchRows matches re against the text of rows y1 through y2, inclusive,
+// joined together with trailing spaces trimmed.
+func (v *VT100) matchRows(re *regexp.Regexp, y1, y2 int) []Match {
+	var text strings.Builder
+
+	// positions maps each byte offset in text to the cell it came from, plus
+	// one extra entry for the end of the text.
+	var positions []Position
+	for y := y1; y <= y2; y++ {
+		for x, r := range v.Content[y] {
+			n, _ := text.WriteRune(r)
+			for i := 0; i < n; i++ {
+				positions = append(positions, Position{Y: y, X: x})
+			}
+		}
+	}
+	s := strings.TrimRight(text.String(), " ")
+	end := Position{Y: y1}
+	if len(s) > 0 {
+		last := positions[len(s)-1]
+		end = Position{Y: last.Y, X: last.X + 1}
+	}
+	positions = append(positions[:len(s)], end)
+
+	var ms []Match
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		m := Match{
+			Start: positions[loc[0]],
+			End:   positions[loc[1]],
+		}
+		for i := 0; i < len(loc); i += 2 {
+			if loc[i] < 0 {
+				m.Submatches = append(m.Submatches, "")
+				continue
+			}
+			m.Submatches = append(m.Submatches, s[loc[i]:loc[i+1]])
+		}
+		ms = append(ms, m)
+	}
+	return ms
+}
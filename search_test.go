@@ -0,0 +1,103 @@
+package vt100_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+	"github.com/vito/vt100/vttest"
+)
+
+func TestFind(t *testing.T) {
+	v := vttest.FromLines("abab\ncdab\n ab \nxyz.")
+
+	y, x, ok := v.Find("ab")
+	assert.True(t, ok)
+	assert.Equal(t, 0, y)
+	assert.Equal(t, 0, x)
+
+	// Matches at the end of a row.
+	y, x, ok = v.Find("cdab")
+	assert.True(t, ok)
+	assert.Equal(t, 1, y)
+	assert.Equal(t, 0, x)
+
+	// Blank cells are matched literally.
+	y, x, ok = v.Find(" ab ")
+	assert.True(t, ok)
+	assert.Equal(t, 2, y)
+	assert.Equal(t, 0, x)
+
+	// Matches never span rows.
+	_, _, ok = v.Find("abcd")
+	assert.False(t, ok)
+
+	_, _, ok = v.Find("XYZ")
+	assert.False(t, ok)
+
+	y, x, ok = v.FindFold("XYZ")
+	assert.True(t, ok)
+	assert.Equal(t, 3, y)
+	assert.Equal(t, 0, x)
+}
+
+func TestFindAll(t *testing.T) {
+	v := vttest.FromLines("abab\ncdab\naaaa")
+
+	assert.Equal(t, []Position{
+		{Y: 0, X: 0},
+		{Y: 0, X: 2},
+		{Y: 1, X: 2},
+	}, v.FindAll("ab"))
+
+	// Matches don't overlap.
+	assert.Equal(t, []Position{
+		{Y: 2, X: 0},
+		{Y: 2, X: 2},
+	}, v.FindAll("aa"))
+
+	assert.Equal(t, []Position{
+		{Y: 0, X: 0},
+		{Y: 0, X: 2},
+		{Y: 1, X: 2},
+	}, v.FindAllFold("AB"))
+
+	assert.Nil(t, v.FindAll("zz"))
+	assert.Nil(t, v.FindAll(""))
+}
+
+func TestMatch(t *testing.T) {
+	v := vttest.FromLines("port 80   \nport 8080 \nnothing   ")
+
+	assert.Equal(t, []Match{
+		{
+			Start:      Position{Y: 0, X: 0},
+			End:        Position{Y: 0, X: 7},
+			Submatches: []string{"port 80", "80"},
+		},
+		{
+			Start:      Position{Y: 1, X: 0},
+			End:        Position{Y: 1, X: 9},
+			Submatches: []string{"port 8080", "8080"},
+		},
+	}, v.Match(regexp.MustCompile(`port (\d+)`)))
+
+	// Trailing spaces are trimmed before matching.
+	assert.Len(t, v.Match(regexp.MustCompile(`nothing$`)), 1)
+}
+
+func TestMatchWrapped(t *testing.T) {
+	v := NewVT100(3, 10)
+	v.Write([]byte("Listening on port 8080\r\n"))
+
+	re := regexp.MustCompile(`Listening on port (\d+)`)
+	assert.Empty(t, v.Match(re))
+	assert.Equal(t, []Match{
+		{
+			Start:      Position{Y: 0, X: 0},
+			End:        Position{Y: 2, X: 2},
+			Submatches: []string{"Listening on port 8080", "8080"},
+		},
+	}, v.MatchWrapped(re))
+}
@@ -0,0 +1,222 @@
+package vt100
+
+import "io"
+
+// StripWriter returns a writer that removes all ANSI escape sequences (CSI,
+// OSC, DCS and the other string sequences, and plain ESC sequences) from what
+// is written to it before passing the rest on to w. Sequences split across
+// several writes are buffered until they are complete, except that a control
+// string longer than DefaultMaxOSCLength is dropped as it arrives instead.
+func StripWriter(w io.Writer) io.Writer {
+	return &escapeFilter{w: w}
+}
+
+// StripColorWriter returns a writer that removes only SGR sequences (e.g.
+// "\x1b[31m"), which set colors and other text attributes, from what is
+// written to it before passing the rest on to w. Cursor movement, erasing,
+// and all other escape sequences are passed through unchanged; a control
+// string longer than DefaultMaxOSCLength is passed on as it arrives rather
+// than buffered until it ends.
+func StripColorWriter(w io.Writer) io.Writer {
+	return &escapeFilter{w: w, keep: notSGR}
+}
+
+// notSGR reports whether seq is anything other than an SGR sequence.
+func notSGR(seq []byte) bool {
+	if len(seq) < 3 || seq[1] != '[' || seq[len(seq)-1] != 'm' {
+		return true
+	}
+	for _, b := range seq[2 : len(seq)-1] {
+		if (b < '0' || b > '9') && b != ';' && b != ':' {
+			return true
+		}
+	}
+	return false
+}
+
+// filterState is the position of an escapeFilter within an escape sequence.
+type filterState int
+
+const (
+	// Not in an escape sequence.
+	filterGround filterState = iota
+	// After ESC.
+	filterEscape
+	// After one or more intermediate bytes of an ESC sequence, e.g. "ESC (".
+	filterEscapeIntermediate
+	// After "ESC [".
+	filterCSI
+	// Within the payload of an OSC, DCS, SOS, PM or APC sequence.
+	filterString
+	// After an ESC within such a payload, possibly starting the ST.
+	filterStringEscape
+)
+
+// escapeFilter is an io.Writer that drops escape sequences for which keep
+// returns false, or all of them if keep is nil.
+type escapeFilter struct {
+	w    io.Writer
+	keep func(seq []byte) bool
+
+	state filterState
+	// seq is the escape sequence read so far.
+	seq []byte
+	// long is set once a control string has grown past DefaultMaxOSCLength,
+	// after which the rest of it is passed on or dropped as it arrives,
+	// according to keepLong, rather than buffered.
+	long     bool
+	keepLong bool
+	// out is reused between writes to hold what's passed on to w.
+	out []byte
+}
+
+func (f *escapeFilter) Write(p []byte) (int, error) {
+	f.out = f.out[:0]
+	for _, b := range p {
+		f.filter(b)
+	}
+
+	if len(f.out) > 0 {
+		if _, err := f.w.Write(f.out); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+func (f *escapeFilter) filter(b byte) {
+	switch f.state {
+	case filterGround:
+		if b == escape {
+			f.begin(b)
+			return
+		}
+		f.out = append(f.out, b)
+
+	case filterEscape:
+		switch {
+		case b == '[':
+			f.state = filterCSI
+		case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+			f.state = filterString
+		case b >= 0x20 && b <= 0x2f:
+			f.state = filterEscapeIntermediate
+		case b >= 0x30 && b <= 0x7e:
+			f.seq = append(f.seq, b)
+			f.end()
+			return
+		case b < 0x20:
+			f.control(b)
+			return
+		default:
+			f.abort(b)
+			return
+		}
+		f.seq = append(f.seq, b)
+
+	case filterEscapeIntermediate:
+		switch {
+		case b >= 0x20 && b <= 0x2f:
+			f.seq = append(f.seq, b)
+		case b >= 0x30 && b <= 0x7e:
+			f.seq = append(f.seq, b)
+			f.end()
+		case b < 0x20:
+			f.control(b)
+		default:
+			f.abort(b)
+		}
+
+	case filterCSI:
+		switch {
+		case b >= 0x20 && b <= 0x3f: // parameters and intermediates
+			f.seq = append(f.seq, b)
+		case b >= 0x40 && b <= 0x7e:
+			f.seq = append(f.seq, b)
+			f.end()
+		case b < 0x20:
+			f.control(b)
+		default:
+			f.abort(b)
+		}
+
+	case filterString:
+		f.seq = append(f.seq, b)
+		switch b {
+		case '\a':
+			f.end()
+		case escape:
+			f.state = filterStringEscape
+		default:
+			if len(f.seq) > DefaultMaxOSCLength {
+				f.spill()
+			}
+		}
+
+	case filterStringEscape:
+		if b == '\\' {
+			f.seq = append(f.seq, b)
+			f.end()
+			return
+		}
+
+		// An ESC that doesn't begin an ST still ends the string, and begins
+		// a new sequence of its own.
+		f.seq = f.seq[:len(f.seq)-1]
+		f.end()
+		f.begin(escape)
+		f.filter(b)
+	}
+}
+
+// begin starts a new escape sequence with b.
+func (f *escapeFilter) begin(b byte) {
+	f.seq = append(f.seq[:0], b)
+	f.state = filterEscape
+}
+
+// spill empties the buffer of a control string that has grown too long,
+// passing on what it holds if the string is kept.
+func (f *escapeFilter) spill() {
+	if !f.long {
+		f.long = true
+		f.keepLong = f.keep != nil && f.keep(f.seq)
+	}
+	if f.keepLong {
+		f.out = append(f.out, f.seq...)
+	}
+	f.seq = f.seq[:0]
+}
+
+// end completes the current escape sequence.
+func (f *escapeFilter) end() {
+	if f.long && f.keepLong || !f.long && f.keep != nil && f.keep(f.seq) {
+		f.out = append(f.out, f.seq...)
+	}
+	f.seq = f.seq[:0]
+	f.long = false
+	f.state = filterGround
+}
+
+// control handles the control character b in the middle of an escape
+// sequence, as Parser does: ESC begins a new sequence and CAN and SUB abandon
+// the current one, but anything else is passed on without interrupting it.
+func (f *escapeFilter) control(b byte) {
+	switch b {
+	case escape:
+		f.begin(b)
+	case cancel, substitute:
+		f.seq = f.seq[:0]
+		f.state = filterGround
+	default:
+		f.out = append(f.out, b)
+	}
+}
+
+// abort discards the current escape sequence, which was interrupted by b,
+// and then handles b as if it weren't in a sequence.
+func (f *escapeFilter) abort(b byte) {
+	f.seq = f.seq[:0]
+	f.state = filterGround
+	f.filter(b)
+}
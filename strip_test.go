@@ -0,0 +1,103 @@
+package vt100_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+func TestStripWriter(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{"plain text\r\n", "plain text\r\n"},
+		{esc("[31mhello") + esc("[0m"), "hello"},
+		{"a" + esc("[1;2H") + "b" + esc("[?1049h") + "c", "abc"},
+		{"a" + esc("]0;title\a") + "b", "ab"},
+		{"a" + esc("]8;;http://example.com"+esc("\\")) + "b", "ab"},
+		{"a" + esc("P1$r0m"+esc("\\")) + "b", "ab"},
+		{"a" + esc("7") + esc("(0") + "b" + esc("8"), "ab"},
+		// An ESC interrupts an unterminated string.
+		{"a" + esc("]0;title") + esc("[1m") + "b", "ab"},
+		// Control characters are passed on without interrupting sequences,
+		// except for CAN and SUB, which abandon them, and ESC.
+		{"a" + esc("[1\nm") + "b", "a\nb"},
+		{"a" + esc("(\r0") + "b", "a\rb"},
+		{"a" + esc("[1\x18m") + "b", "amb"},
+		{"a" + esc("[1"+esc("[2J")) + "b", "ab"},
+		{"ünïcødé", "ünïcødé"},
+	} {
+		var buf bytes.Buffer
+		w := StripWriter(&buf)
+		n, err := w.Write([]byte(tc.in))
+		assert.Nil(t, err)
+		assert.Equal(t, len(tc.in), n)
+		assert.Equal(t, tc.want, buf.String(), "while stripping %q", tc.in)
+	}
+}
+
+func TestStripWriterSplit(t *testing.T) {
+	in := "a" + esc("[31m") + "b" + esc("]0;title"+esc("\\")) + "c" + esc("(B") + "d"
+
+	// Split the input at every possible point.
+	for i := 0; i <= len(in); i++ {
+		var buf bytes.Buffer
+		w := StripWriter(&buf)
+		w.Write([]byte(in[:i]))
+		w.Write([]byte(in[i:]))
+		assert.Equal(t, "abcd", buf.String(), "while splitting at %d", i)
+	}
+}
+
+func TestStripColorWriter(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+	}{
+		{esc("[31mhello") + esc("[0m"), "hello"},
+		{esc("[1Ahello"), esc("[1Ahello")},
+		{esc("[38:2::255:0:0mred") + esc("[m"), "red"},
+		{"a" + esc("[2J") + esc("[1;2H") + esc("[1m") + "b", "a" + esc("[2J") + esc("[1;2H") + "b"},
+		// Private sequences that happen to end in m aren't SGR.
+		{esc("[>4;2m") + "a", esc("[>4;2m") + "a"},
+		{esc("]0;title\a") + esc("(B"), esc("]0;title\a") + esc("(B")},
+		// Control characters within a sequence come out ahead of it.
+		{esc("[1\nm") + "a" + esc("[1\nA"), "\na\n" + esc("[1A")},
+	} {
+		var buf bytes.Buffer
+		w := StripColorWriter(&buf)
+		n, err := w.Write([]byte(tc.in))
+		assert.Nil(t, err)
+		assert.Equal(t, len(tc.in), n)
+		assert.Equal(t, tc.want, buf.String(), "while stripping %q", tc.in)
+	}
+}
+
+func TestStripWriterLongString(t *testing.T) {
+	payload := strings.Repeat("x", 3*DefaultMaxOSCLength)
+
+	var buf bytes.Buffer
+	w := StripWriter(&buf)
+	w.Write([]byte("a" + esc("]0;"+payload)))
+	w.Write([]byte(payload + "\a" + "b"))
+	assert.Equal(t, "ab", buf.String())
+
+	// Strings that are kept are passed on before they end rather than
+	// buffered indefinitely.
+	buf.Reset()
+	w = StripColorWriter(&buf)
+	w.Write([]byte("a" + esc("]0;"+payload)))
+	assert.True(t, buf.Len() > 2*DefaultMaxOSCLength)
+	w.Write([]byte(payload + esc("\\") + "b"))
+	assert.Equal(t, "a"+esc("]0;"+payload+payload+esc("\\"))+"b", buf.String())
+
+	// An ESC still interrupts an unterminated string after it has grown long.
+	buf.Reset()
+	w = StripWriter(&buf)
+	w.Write([]byte("a" + esc("]0;"+payload) + esc("[1m") + "b"))
+	assert.Equal(t, "ab", buf.String())
+}
@@ -0,0 +1,28 @@
+package vt100
+
+import "io"
+
+// TeeWriter returns a writer that writes everything written to it to both v
+// and w, e.g. to display a program's output while also logging it.
+//
+// v is always updated with all of the data first, so it isn't left partway
+// through a write if w fails. Errors from w are returned, but v's never are:
+// like Write, it handles everything given to it.
+func TeeWriter(v *VT100, w io.Writer) io.Writer {
+	return &teeWriter{v: v, w: w}
+}
+
+type teeWriter struct {
+	v *VT100
+	w io.Writer
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	t.v.Write(p)
+
+	n, err := t.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
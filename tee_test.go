@@ -0,0 +1,63 @@
+package vt100_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+func TestTeeWriter(t *testing.T) {
+	v := NewVT100(1, 10)
+	var buf bytes.Buffer
+	w := TeeWriter(v, &buf)
+
+	in := "a" + esc("[1m") + "b" + esc("]0;ti")
+	n, err := w.Write([]byte(in))
+	assert.Nil(t, err)
+	assert.Equal(t, len(in), n)
+
+	n, err = w.Write([]byte("tle\a" + "c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+
+	assert.Equal(t, in+"tle\ac", buf.String())
+	assert.Equal(t, "abc       ", string(v.Content[0]))
+	assert.Equal(t, Bold, v.Format[0][1].Intensity)
+}
+
+// failingWriter accepts n bytes and then fails.
+type failingWriter struct {
+	n int
+}
+
+var errFailed = errors.New("failed")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, errFailed
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestTeeWriterError(t *testing.T) {
+	v := NewVT100(1, 10)
+	w := TeeWriter(v, &failingWriter{n: 3})
+
+	n, err := w.Write([]byte("hello"))
+	assert.Equal(t, errFailed, err)
+	assert.Equal(t, 3, n)
+
+	// The terminal gets all of the write regardless.
+	assert.Equal(t, "hello     ", string(v.Content[0]))
+	assert.Equal(t, 5, v.Cursor.X)
+
+	_, err = w.Write([]byte("!"))
+	assert.Equal(t, errFailed, err)
+	assert.Equal(t, "hello!    ", string(v.Content[0]))
+}
@@ -13,11 +13,16 @@ package vt100
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"sort"
 	"strings"
 	"sync"
+	"unicode"
+	"unicode/utf8"
+	"unsafe"
 
 	"github.com/muesli/termenv"
 )
@@ -44,6 +49,34 @@ func (i Intensity) alpha() uint8 {
 	}
 }
 
+// UnderlineStyle is the style of the line drawn under underlined text.
+type UnderlineStyle int
+
+const (
+	NoUnderline UnderlineStyle = iota
+	SingleUnderline
+	DoubleUnderline
+	CurlyUnderline
+	DottedUnderline
+	DashedUnderline
+)
+
+// css returns the text-decoration-style for u, or "" for the default.
+func (u UnderlineStyle) css() string {
+	switch u {
+	case DoubleUnderline:
+		return "double"
+	case CurlyUnderline:
+		return "wavy"
+	case DottedUnderline:
+		return "dotted"
+	case DashedUnderline:
+		return "dashed"
+	default:
+		return ""
+	}
+}
+
 // Format represents the display format of text on a terminal.
 type Format struct {
 	// Reset inidcates that the format should be reset prior to applying any of
@@ -57,15 +90,55 @@ type Format struct {
 	Intensity Intensity
 	// Various text properties.
 	Italic, Underline, Blink, Reverse, Conceal, CrossOut, Overline bool
+	// UnderlineStyle is the style of the underline. Text is underlined if
+	// either this or Underline is set; Underline alone means a single
+	// underline.
+	UnderlineStyle UnderlineStyle
+	// RapidBlink is set instead of Blink for rapidly blinking text.
+	RapidBlink bool
+	// UnderlineColor is the color of the underline. If nil, the underline is
+	// drawn in the foreground color.
+	UnderlineColor termenv.Color
+	// Protected cells are skipped by selective erases. It's set by DECSCA
+	// rather than SGR, so resetting the other attributes leaves it alone.
+	Protected bool
+}
+
+// The colors used for cells that don't set their own, unless DefaultFg or
+// DefaultBg are set, matching a classic VGA console.
+var (
+	defaultFg termenv.Color = termenv.RGBColor("#aaaaaa")
+	defaultBg termenv.Color = termenv.RGBColor("#000000")
+)
+
+// defaultColors returns the colors for cells that don't set their own.
+func (v *VT100) defaultColors() (fg, bg termenv.Color) {
+	fg, bg = defaultFg, defaultBg
+	if v.DefaultFg != nil {
+		fg = v.DefaultFg
+	}
+	if v.DefaultBg != nil {
+		bg = v.DefaultBg
+	}
+	return fg, bg
 }
 
 func toCss(c termenv.Color) string {
 	return termenv.ConvertToRGB(c).Hex()
 }
 
-func (f Format) css() string {
+// css returns the inline style for f, using defFg and defBg for the colors
+// it doesn't set. If blinkClasses is set, blinking is left to the class
+// returned by blinkClass instead.
+func (f Format) css(blinkClasses bool, defFg, defBg termenv.Color) string {
 	parts := make([]string, 0)
 	fg, bg := f.Fg, f.Bg
+	if fg == nil {
+		fg = defFg
+	}
+	if bg == nil {
+		bg = defBg
+	}
 	if f.Reverse {
 		bg, fg = fg, bg
 	}
@@ -79,14 +152,41 @@ func (f Format) css() string {
 	case Faint:
 		parts = append(parts, "opacity:0.33")
 	}
-	if f.Underline {
-		parts = append(parts, "text-decoration:underline")
+	if f.Italic {
+		parts = append(parts, "font-style:italic")
 	}
 	if f.Conceal {
-		parts = append(parts, "display:none")
+		parts = append(parts, "visibility:hidden")
+	}
+	if f.RapidBlink && !blinkClasses {
+		parts = append(parts, "animation:blink 0.2s step-end infinite")
+	}
+
+	// text-decoration is a single property, so all of the decoration
+	// attributes have to be combined into one value or they would override
+	// each other.
+	underline := f.Underline || f.UnderlineStyle != NoUnderline
+	var decorations []string
+	if underline {
+		decorations = append(decorations, "underline")
 	}
-	if f.Blink {
-		parts = append(parts, "text-decoration:blink")
+	if f.Overline {
+		decorations = append(decorations, "overline")
+	}
+	if f.CrossOut {
+		decorations = append(decorations, "line-through")
+	}
+	if f.Blink && !blinkClasses {
+		decorations = append(decorations, "blink")
+	}
+	if len(decorations) > 0 {
+		parts = append(parts, "text-decoration:"+strings.Join(decorations, " "))
+	}
+	if style := f.UnderlineStyle.css(); underline && style != "" {
+		parts = append(parts, "text-decoration-style:"+style)
+	}
+	if f.UnderlineColor != nil {
+		parts = append(parts, "text-decoration-color:"+toCss(f.UnderlineColor))
 	}
 
 	// We're not in performance sensitive code. Although this sort
@@ -99,9 +199,77 @@ func (f Format) css() string {
 	return strings.Join(parts, ";")
 }
 
+// blinkClass returns the HTML class for f's blinking, if any.
+func (f Format) blinkClass() string {
+	switch {
+	case f.RapidBlink:
+		return "rapid-blink"
+	case f.Blink:
+		return "blink"
+	default:
+		return ""
+	}
+}
+
+// MouseMode is the kind of mouse events that the program running in the
+// terminal has asked to be reported. The values are the numbers of the DEC
+// private modes that enable them.
+type MouseMode int
+
+const (
+	MouseNone MouseMode = 0
+	// MouseX10 reports button presses.
+	MouseX10 MouseMode = 9
+	// MouseNormal reports button presses and releases.
+	MouseNormal MouseMode = 1000
+	// MouseButtonEvent additionally reports motion while a button is held.
+	MouseButtonEvent MouseMode = 1002
+	// MouseAnyEvent reports all motion.
+	MouseAnyEvent MouseMode = 1003
+)
+
+// MouseEncoding is the format that the program running in the terminal has
+// asked mouse events to be reported in. The values are the numbers of the DEC
+// private modes that enable them.
+type MouseEncoding int
+
+const (
+	MouseEncodingDefault MouseEncoding = 0
+	MouseEncodingUTF8    MouseEncoding = 1005
+	MouseEncodingSGR     MouseEncoding = 1006
+	MouseEncodingURXVT   MouseEncoding = 1015
+)
+
+// Charset is a character set that can be designated as G0 or G1, changing
+// the glyphs that printed characters are displayed as.
+type Charset int
+
+const (
+	// CharsetASCII is the usual character set (ESC ( B).
+	CharsetASCII Charset = iota
+	// CharsetLineDrawing is the DEC Special Graphics set (ESC ( 0), which
+	// replaces lowercase letters and some punctuation with line drawing
+	// characters and other symbols.
+	CharsetLineDrawing
+)
+
+// lineDrawing maps the characters '_' through '~' to the glyphs they're
+// displayed as in CharsetLineDrawing.
+var lineDrawing = []rune(" ◆▒␉␌␍␊°±␤␋┘┐┌└┼⎺⎻─⎼⎽├┤┴┬│≤≥π≠£·")
+
+// translate returns the glyph that r is displayed as in c.
+func (c Charset) translate(r rune) rune {
+	if c == CharsetLineDrawing && r >= '_' && r <= '~' {
+		return lineDrawing[r-'_']
+	}
+	return r
+}
+
 // Cursor represents both the position and text type of the cursor.
 type Cursor struct {
-	// Y and X are the coordinates.
+	// Y and X are the coordinates. Y can be one past the bottom row, and X,
+	// with AutoResizeX, one or more past the last column: the terminal
+	// scrolls or grows to fit when the next rune is written.
 	Y, X int
 
 	// F is the format that will be displayed.
@@ -114,88 +282,830 @@ type VT100 struct {
 	Height, Width int
 
 	// Content is the text in the terminal.
+	//
+	// Reading it while the terminal is being written to is a data race; use
+	// ContentCopy, or Snapshot, instead.
 	Content [][]rune
 
 	// Format is the display properties of each cell.
+	//
+	// As with Content, use FormatCopy, or Snapshot, to read it while the
+	// terminal is being written to.
 	Format [][]Format
 
+	// LineWrapped records, for each row, whether it continues the row above,
+	// because text was automatically wrapped onto it. A row stops being one
+	// if the end of the row before it is erased, or if a newline, carriage
+	// return, or CUP ends the line before anything is written on it.
+	//
+	// As with Content, reading it while the terminal is being written to is
+	// a data race.
+	LineWrapped []bool
+
 	// Cursor is the current state of the cursor.
 	Cursor Cursor
 
 	// AutoResizeY indicates whether the terminal should automatically resize
-	// when the content exceeds its maximum height.
+	// when the content exceeds its maximum height. It takes precedence over
+	// Overflow.
 	AutoResizeY bool
 
+	// Overflow is what happens when the content runs past the bottom of the
+	// terminal.
+	Overflow OverflowBehavior
+
+	// MaxHeight, if greater than zero, is the tallest that AutoResizeY, or
+	// OverflowResizeY, lets the terminal grow. Past that, it scrolls instead,
+	// or with OverflowClamp, overwrites its last line. Without it, the
+	// cursor still can't be moved more than 1000 rows past the bottom at
+	// once, and likewise for columns without MaxWidth.
+	MaxHeight int
+
 	// AutoResizeX indicates whether the terminal should automatically resize
 	// when the content exceeds its maximum width.
 	AutoResizeX bool
 
+	// MaxWidth, if greater than zero, is the widest that AutoResizeX lets the
+	// terminal grow. Lines any longer than that wrap, as they would without
+	// AutoResizeX, unless TruncateAtMaxWidth is set, in which case the rest of
+	// the line is dropped.
+	MaxWidth           int
+	TruncateAtMaxWidth bool
+
+	// Reflow makes the terminal re-wrap its lines when its width changes, so
+	// that lines which were wrapped automatically are wrapped at the new
+	// width rather than cut off or left short.
+	Reflow bool
+
 	// DebugLogs is a location to print ANSI parse errors and other debugging
 	// information.
 	DebugLogs io.Writer
 
-	// savedCursor is the state of the cursor last time save() was called.
-	savedCursor Cursor
+	// ScrollbackWriter, if set, is written the text of each row that goes to
+	// the scrollback, with trailing blanks trimmed, followed by a newline.
+	// It's written whether or not the scrollback is kept, and has no limit,
+	// so it suits spilling the output of a long-running program to a file.
+	ScrollbackWriter io.Writer
+
+	// EventLog, if set, is written a line of JSON describing each command
+	// that's applied to the terminal, as an Event. It's separate from
+	// DebugLogs, which is only for errors, and slows writing down, so it's
+	// best left unset except while debugging.
+	EventLog io.Writer
+
+	// DebugFunc, if set, is called instead of printing to DebugLogs, with
+	// the details of each error found while writing. It's called with the
+	// terminal locked, so it mustn't call any of its methods.
+	DebugFunc func(event DebugEvent)
+
+	// MaxOSCLength is the most bytes of data that an OSC sequence, or any
+	// other control string, may carry. Longer ones are discarded in their
+	// entirety once they end, rather than buffered. It bounds the memory used
+	// by a program that starts a control string and never ends it. Zero or
+	// less means there's no limit.
+	MaxOSCLength int
+
+	// MaxParams is the most parameters of a CSI sequence that are parsed, and
+	// MaxParamValue is the largest value any of them may have. Any more
+	// parameters are ignored, and larger values are clamped. Zero or less
+	// means there's no limit.
+	MaxParams, MaxParamValue int
+
+	// OriginMode (DECOM) makes cursor addressing relative to the margins
+	// rather than the screen, and keeps cursor motion within them.
+	OriginMode bool
+
+	// LeftRightMarginMode (DECLRMM) enables the left and right margins.
+	LeftRightMarginMode bool
+
+	// InsertMode (IRM, set by CSI 4 h) makes written text push the rest of
+	// the row to the right, rather than overwrite it. Whatever is pushed past
+	// the right edge is lost.
+	InsertMode bool
+
+	// ReverseWrap (DECSET 45) makes backspace at the first column move to the
+	// last column of the row above. Otherwise, it stays put, unless the
+	// cursor has only just wrapped onto the row.
+	ReverseWrap bool
+
+	// VT52Mode (set by resetting DECANM, with CSI ? 2 l) makes the terminal
+	// understand VT52 escape sequences rather than ANSI ones, until it's
+	// given "ESC <".
+	VT52Mode bool
+
+	// FixedColumns makes the terminal ignore DECCOLM, which would otherwise
+	// set its width to 80 or 132 columns. It's for when the host controls the
+	// size of the terminal.
+	FixedColumns bool
+
+	// LeftMargin and RightMargin are the 0-indexed, inclusive columns that
+	// bound the cursor when LeftRightMarginMode is set. They are reset to the
+	// edges of the screen whenever its width changes.
+	LeftMargin, RightMargin int
+
+	// TopMargin and BottomMargin are the 0-indexed, inclusive rows set by
+	// DECSTBM. Unless they're the edges of the screen, linefeeds and SU and
+	// SD scroll only the rows between them, which are lost rather than kept
+	// in the scrollback. In origin mode, rows are addressed relative to
+	// TopMargin and can't pass BottomMargin. They are reset to the edges of
+	// the screen whenever its size changes.
+	TopMargin, BottomMargin int
+
+	// DefaultFg and DefaultBg are the colors that HTML gives cells whose
+	// formats don't set their own. If nil, light gray and black are used.
+	DefaultFg, DefaultBg termenv.Color
+
+	// DefaultFormat is the format of blank cells, whether they're blank from
+	// the start or have been erased or scrolled in.
+	DefaultFormat Format
+
+	// MouseMode is the kind of mouse reporting requested by the program. The
+	// terminal does not report mouse events itself; this is only tracked so
+	// that the host can.
+	MouseMode MouseMode
+
+	// MouseEncoding is the mouse reporting format requested by the program.
+	MouseEncoding MouseEncoding
+
+	// G0 and G1 are the character sets designated by ESC ( and ESC ). G0 is
+	// used unless ShiftOut is set, by SO, in which case G1 is used until SI.
+	G0, G1   Charset
+	ShiftOut bool
+
+	// SynchronizedOutput is set while the program is in the middle of an
+	// atomic update (DEC private mode 2026). Renderers may skip drawing while
+	// it is set.
+	SynchronizedOutput bool
+
+	// OnFrame, if set, is called each time SynchronizedOutput ends, i.e. when
+	// an atomic update is complete and the screen is safe to render. It is
+	// called once the Write or Process that ended the update returns control
+	// of the terminal, so it may inspect the terminal.
+	OnFrame func()
+
+	// OnScroll, if set, is called with the text and formats of each row as it
+	// scrolls off the top of the terminal. They're the terminal's own slices,
+	// about to be reused, so OnScroll mustn't keep or modify them. It's
+	// called while the terminal is locked, so it mustn't use the terminal.
+	OnScroll func(content []rune, format []Format)
+
+	// Metrics, if set, is told what the terminal does.
+	Metrics Metrics
+
+	// pendingFrames is the number of times synchronized output has ended
+	// since OnFrame was last called.
+	pendingFrames int
+
+	// linesScrolled counts the rows that have scrolled off the top.
+	linesScrolled int
+
+	// metrics accumulates counts for Metrics until v is unlocked.
+	metrics metricCounts
+
+	// hash is the result of Hash, if hashValid is set. hashMut guards both,
+	// since Hash only holds mut for reading.
+	hash      uint64
+	hashValid bool
+	hashMut   sync.Mutex
+
+	// damage accumulates the effects of the write in progress, if it's being
+	// tracked.
+	damage *Damage
+
+	// savedCursors is a stack of saved cursor states. DECSC and DECRC use the
+	// top of it, and PushCursor and PopCursor grow and shrink it.
+	savedCursors []SavedState
+
+	// scrollback holds the lines that scrolled off the top of the terminal,
+	// oldest first, up to scrollbackLimit of them.
+	scrollback      []scrollbackLine
+	scrollbackLimit int
+
+	// wrappedTo is the row that the cursor just wrapped onto, if nothing has
+	// been written since, or else zero. See unwrap. If it's Height, the wrap
+	// is marked in LineWrapped once the terminal scrolls or grows to make
+	// room for the row.
+	wrappedTo int
+
+	// regionWrap is set when the last column of the bottom row of a
+	// scrolling region has just been written. The cursor stays there, rather
+	// than wrapping eagerly, until there's more to write, since wrapping
+	// scrolls the region.
+	regionWrap bool
+
+	// parser decodes the data passed to write, keeping hold of anything
+	// that's cut off at the end of it.
+	parser Parser
+
+	// maxY is the lowest row that a character has been printed on. It moves
+	// with the content when the terminal scrolls, and is -1 if there's no
+	// such row.
+	maxY int
 
-	unparsed []byte
+	// updated is closed and cleared whenever the terminal changes, waking
+	// anyone waiting on it. It is only allocated while someone is waiting.
+	updated chan struct{}
 
-	// maxY is the maximum vertical offset that a character was printed
-	maxY int
+	// updates is the channel returned by Updates.
+	updates chan struct{}
 
-	// for synchronizing e.g. writes and async resizing
-	mut sync.Mutex
+	// for synchronizing e.g. writes and async resizing. Anything that changes
+	// the terminal holds it exclusively; pure readers share it.
+	mut sync.RWMutex
 }
 
-// NewVT100 creates a new VT100 object with the specified dimensions. y and x
-// must both be greater than zero.
+// The limits that a new VT100 has on the sequences written to it.
+const (
+	DefaultMaxOSCLength  = 4096
+	DefaultMaxParams     = 32
+	DefaultMaxParamValue = 65535
+)
+
+// ErrInvalidDimensions is returned when a terminal is asked to have zero or
+// fewer rows or columns.
+var ErrInvalidDimensions = errors.New("invalid dimensions")
+
+// NewVT100 creates a new VT100 object with the specified dimensions.
+//
+// NewVT100 panics unless y and x are both greater than zero. Use
+// NewVT100Safe, or New, to get an error instead.
 //
 // Each cell is set to contain a ' ' rune, and all formats are left as the
 // default.
 func NewVT100(y, x int) *VT100 {
+	v, err := New(WithSize(y, x))
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// NewVT100Safe is like NewVT100, but returns ErrInvalidDimensions rather than
+// panicking if y or x is zero or negative.
+func NewVT100Safe(y, x int) (*VT100, error) {
 	if y <= 0 || x <= 0 {
-		panic(fmt.Errorf("invalid dim (%d, %d)", y, x))
+		return nil, ErrInvalidDimensions
+	}
+	return New(WithSize(y, x))
+}
+
+// UsedHeight returns the number of rows from the top of the terminal down to
+// the lowest one that text has been written on, following that text as the
+// terminal scrolls. Once everything written has scrolled off the top, it's
+// zero.
+func (v *VT100) UsedHeight() int {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+	return v.maxY + 1
+}
+
+// ResetUsed makes UsedHeight zero again, as if nothing had been written, but
+// leaves the content of the terminal alone. Erasing the whole display does
+// this too.
+func (v *VT100) ResetUsed() {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	v.maxY = -1
+}
+
+// Reset puts the terminal back as it was when it was made, as RIS would: the
+// screen and scrollback are erased, LinesScrolled goes back to zero, the
+// cursor goes home in the default format, and the modes, margins, character
+// sets and saved cursors are reset. The size of the terminal, and the fields
+// that configure it, such as its limits and callbacks, are left alone.
+//
+// ESC c isn't handled as RIS, because it decodes to the same command as
+// CSI c, which asks for the terminal's attributes rather than resetting it.
+func (v *VT100) Reset() {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	defer v.notify()
+
+	v.eraseLines(EraseScrollback, false)
+	v.Cursor = Cursor{}
+	v.savedCursors = nil
+	v.G0, v.G1, v.ShiftOut = CharsetASCII, CharsetASCII, false
+	v.OriginMode, v.LeftRightMarginMode = false, false
+	v.InsertMode, v.ReverseWrap, v.VT52Mode = false, false, false
+	v.MouseMode, v.MouseEncoding = MouseNone, MouseEncodingDefault
+	v.SynchronizedOutput = false
+	v.LeftMargin, v.RightMargin = 0, v.Width-1
+	v.TopMargin, v.BottomMargin = 0, v.Height-1
+	v.wrappedTo, v.regionWrap = 0, false
+	for y := range v.LineWrapped {
+		v.LineWrapped[y] = false
+	}
+}
+
+// Line returns the text of row y along with the format of each of its
+// cells.
+func (v *VT100) Line(y int) (string, []Format, error) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	if y < 0 || y >= v.Height {
+		return "", nil, fmt.Errorf("row %d out of bounds (%d)", y, v.Height)
 	}
 
-	v := &VT100{
-		Height:  y,
-		Width:   x,
-		Content: make([][]rune, y),
-		Format:  make([][]Format, y),
+	formats := make([]Format, v.Width)
+	copy(formats, v.Format[y])
+	return string(v.Content[y]), formats, nil
+}
+
+// Row returns copies of the runes and formats of row y, or false if it's out
+// of bounds. The copies are safe to hold on to while the terminal is written
+// to.
+func (v *VT100) Row(y int) (runes []rune, formats []Format, ok bool) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
 
-		// start at -1 so there's no "used" height until first write
-		maxY: -1,
+	if y < 0 || y >= v.Height {
+		return nil, nil, false
 	}
 
-	for row := 0; row < y; row++ {
-		v.Content[row] = make([]rune, x)
-		v.Format[row] = make([]Format, x)
+	runes = make([]rune, v.Width)
+	copy(runes, v.Content[y])
+	formats = make([]Format, v.Width)
+	copy(formats, v.Format[y])
+	return runes, formats, true
+}
 
-		for col := 0; col < x; col++ {
-			v.clear(row, col)
+// EachLine calls fn with the runes and formats of each used row, in order
+// from the top, as counted by UsedHeight. It stops early if fn returns false.
+//
+// The terminal is locked for reading throughout, so fn mustn't change it. The
+// slices are the terminal's own, not copies, so fn mustn't modify them or
+// keep them after it returns.
+func (v *VT100) EachLine(fn func(y int, content []rune, format []Format) bool) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	for y := 0; y <= v.maxY && y < v.Height; y++ {
+		if !fn(y, v.Content[y], v.Format[y]) {
+			return
 		}
 	}
+}
 
-	return v
+// TrimmedLine is like Line, but omits the trailing blank cells of the row,
+// i.e. those holding a ' ' with the default format.
+func (v *VT100) TrimmedLine(y int) (string, []Format, error) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	if y < 0 || y >= v.Height {
+		return "", nil, fmt.Errorf("row %d out of bounds (%d)", y, v.Height)
+	}
+
+	n := v.trimmedWidth(y)
+	formats := make([]Format, n)
+	copy(formats, v.Format[y])
+	return string(v.Content[y][:n]), formats, nil
 }
 
-func (v *VT100) UsedHeight() int {
+// TrimmedLines returns the text of every row, as TrimmedLine would.
+func (v *VT100) TrimmedLines() []string {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	lines := make([]string, v.Height)
+	for y := range lines {
+		lines[y] = string(v.Content[y][:v.trimmedWidth(y)])
+	}
+	return lines
+}
+
+// A LogicalLine is a line of text as it was written, before it was wrapped
+// onto however many rows it took up.
+type LogicalLine struct {
+	Runes   []rune
+	Formats []Format
+	// WrappedAt holds the offsets in Runes at which each row after the first
+	// begins, leaving out any rows at the end that add nothing to the line.
+	WrappedAt []int
+}
+
+// LogicalLines returns the terminal's rows joined into the lines they were
+// wrapped from, from the top, with the trailing blank cells of each line
+// omitted as by TrimmedLine.
+func (v *VT100) LogicalLines() []LogicalLine {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	var lines []LogicalLine
+	var cur LogicalLine
+	for y := 0; y < v.Height; y++ {
+		if v.LineWrapped[y] {
+			cur.WrappedAt = append(cur.WrappedAt, len(cur.Runes))
+		}
+		if y < v.Height-1 && v.LineWrapped[y+1] {
+			cur.Runes = append(cur.Runes, v.Content[y]...)
+			cur.Formats = append(cur.Formats, v.Format[y]...)
+			continue
+		}
+
+		n := v.trimmedWidth(y)
+		cur.Runes = append(cur.Runes, v.Content[y][:n]...)
+		cur.Formats = append(cur.Formats, v.Format[y][:n]...)
+		for len(cur.WrappedAt) > 0 && cur.WrappedAt[len(cur.WrappedAt)-1] == len(cur.Runes) {
+			cur.WrappedAt = cur.WrappedAt[:len(cur.WrappedAt)-1]
+		}
+		if len(cur.WrappedAt) == 0 {
+			cur.WrappedAt = nil
+		}
+		lines = append(lines, cur)
+		cur = LogicalLine{}
+	}
+	return lines
+}
+
+// trimmedWidth returns the width of row y without its trailing blank cells,
+// i.e. those holding a ' ' with DefaultFormat.
+func (v *VT100) trimmedWidth(y int) int {
+	n := v.Width
+	for n > 0 && v.Content[y][n-1] == ' ' && v.Format[y][n-1] == v.DefaultFormat {
+		n--
+	}
+	return n
+}
+
+// CurrentFormat returns the format that text is written in, as set by SGR
+// sequences or SetFormat.
+func (v *VT100) CurrentFormat() Format {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+	return v.Cursor.F
+}
+
+// SetFormat sets the format that text is written in from now on, as an SGR
+// sequence would.
+func (v *VT100) SetFormat(f Format) {
 	v.mut.Lock()
 	defer v.mut.Unlock()
-	return v.maxY + 1
+	v.Cursor.F = f
+}
+
+// PutRune puts r at the cursor in the current format, then advances the
+// cursor, just as if r had been written. Unlike a write, control characters
+// are put as they are rather than carried out.
+func (v *VT100) PutRune(r rune) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	v.put(r)
+	v.notify()
+}
+
+// PutLiteral puts each rune of s at the cursor in the current format, as
+// PutRune does, wrapping and scrolling as a write would. No part of s is
+// taken as a control character or escape sequence: an ESC, for example, just
+// fills a cell, which HTML and DebugDump show as a control picture.
+func (v *VT100) PutLiteral(s string) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	for _, r := range s {
+		v.put(r)
+	}
+	v.notify()
+}
+
+// WriteAt puts text onto the terminal starting at row y and column x, in the
+// format f, leaving the cursor after it. The format that text is otherwise
+// written in is left alone. Like PutRune, WriteAt puts control characters as
+// they are.
+//
+// If y or x are out of bounds, they're clamped to the edges of the terminal,
+// and an error is returned once the text has been written there.
+func (v *VT100) WriteAt(y, x int, text string, f Format) error {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+
+	y, x, err := sanitize(v, y, x)
+	v.home(y, x)
+	prev := v.Cursor.F
+	v.Cursor.F = f
+	for _, r := range text {
+		v.put(r)
+	}
+	v.Cursor.F = prev
+	v.notify()
+	return err
+}
+
+// Cell is the contents of one cell of the terminal.
+type Cell struct {
+	Rune   rune
+	Format Format
+}
+
+// CellAt returns the cell at row y and column x, or false if it's out of
+// bounds.
+func (v *VT100) CellAt(y, x int) (Cell, bool) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	if y < 0 || y >= v.Height || x < 0 || x >= v.Width {
+		return Cell{}, false
+	}
+	return Cell{v.Content[y][x], v.Format[y][x]}, true
+}
+
+// FormatAt returns the format of the cell at row y and column x, or false if
+// it's out of bounds.
+func (v *VT100) FormatAt(y, x int) (Format, bool) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	if y < 0 || y >= v.Height || x < 0 || x >= v.Width {
+		return Format{}, false
+	}
+	return v.Format[y][x], true
+}
+
+// SetCell sets the rune and format of the cell at row y and column x. It
+// does nothing if the cell is out of bounds.
+func (v *VT100) SetCell(y, x int, c Cell) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+
+	if y < 0 || y >= v.Height || x < 0 || x >= v.Width {
+		return
+	}
+	v.Content[y][x] = c.Rune
+	v.Format[y][x] = c.Format
+	v.damage.touch(y, y)
+	v.notify()
+}
+
+// SetFormatAt sets the format of the cell at row y and column x, leaving its
+// rune alone. Coordinates out of bounds are clamped to the edges of the
+// terminal.
+//
+// It's named so as not to clash with SetFormat, which sets the format that
+// text is written in.
+func (v *VT100) SetFormatAt(y, x int, f Format) {
+	v.SetFormatRegion(y, x, y, x, f)
+}
+
+// SetFormatRegion sets the format of every cell in the rectangle from (y1,
+// x1) to (y2, x2), inclusive, leaving their runes alone. Coordinates out of
+// bounds are clamped to the edges of the terminal.
+func (v *VT100) SetFormatRegion(y1, x1, y2, x2 int, f Format) {
+	v.updateFormats(y1, x1, y2, x2, func(Format) Format { return f })
+}
+
+// HighlightRegion is like SetFormatRegion, but merges h onto the format of
+// each cell rather than replacing it: the fields of h that are set override
+// the cell's, and the rest are left as they were. This is useful for
+// overlaying, say, search results on formatted output.
+func (v *VT100) HighlightRegion(y1, x1, y2, x2 int, h Format) {
+	v.updateFormats(y1, x1, y2, x2, func(f Format) Format { return f.overlay(h) })
+}
+
+// overlay returns f with the fields that are set in h, i.e. not their zero
+// value, replaced by h's.
+func (f Format) overlay(h Format) Format {
+	if h.Reset {
+		f.Reset = true
+	}
+	if h.Fg != nil {
+		f.Fg = h.Fg
+	}
+	if h.Bg != nil {
+		f.Bg = h.Bg
+	}
+	if h.Intensity != Normal {
+		f.Intensity = h.Intensity
+	}
+	f.Italic = f.Italic || h.Italic
+	f.Underline = f.Underline || h.Underline
+	f.Blink = f.Blink || h.Blink
+	f.Reverse = f.Reverse || h.Reverse
+	f.Conceal = f.Conceal || h.Conceal
+	f.CrossOut = f.CrossOut || h.CrossOut
+	f.Overline = f.Overline || h.Overline
+	if h.UnderlineStyle != NoUnderline {
+		f.UnderlineStyle = h.UnderlineStyle
+	}
+	f.RapidBlink = f.RapidBlink || h.RapidBlink
+	if h.UnderlineColor != nil {
+		f.UnderlineColor = h.UnderlineColor
+	}
+	f.Protected = f.Protected || h.Protected
+	return f
+}
+
+// updateFormats replaces the format of every cell in the rectangle from (y1,
+// x1) to (y2, x2), inclusive, with the result of update, clamping the
+// coordinates to the edges of the terminal.
+func (v *VT100) updateFormats(y1, x1, y2, x2 int, update func(Format) Format) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+
+	y1, x1, _ = sanitize(v, y1, x1)
+	y2, x2, _ = sanitize(v, y2, x2)
+	if y1 > y2 {
+		y1, y2 = y2, y1
+	}
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+
+	for y := y1; y <= y2; y++ {
+		for x := x1; x <= x2; x++ {
+			v.Format[y][x] = update(v.Format[y][x])
+		}
+	}
+	v.damage.touch(y1, y2)
+	v.notify()
+}
+
+// Shift translates the contents of the terminal down by dy rows and right by
+// dx columns. Negative offsets shift up and left. Cells that are vacated are
+// cleared, and content shifted past the edges is discarded. The cursor is not
+// moved.
+func (v *VT100) Shift(dy, dx int) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	v.shift(dy, dx)
+	v.notify()
+}
+
+// clamp returns x, or lo or hi if it's beyond them.
+func clamp(x, lo, hi int) int {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
+
+func (v *VT100) shift(dy, dx int) {
+	// Shifting by the whole size clears everything already, and clamping to
+	// it keeps the arithmetic below from overflowing.
+	dy, dx = clamp(dy, -v.Height, v.Height), clamp(dx, -v.Width, v.Width)
+
+	if dy != 0 {
+		h := v.Height
+		content := make([][]rune, h)
+		format := make([][]Format, h)
+		wrapped := make([]bool, h)
+		for y := 0; y < h; y++ {
+			// Rotate the rows so that each one is reused exactly once, then
+			// blank the ones that wrapped around.
+			src := ((y-dy)%h + h) % h
+			content[y] = v.Content[src]
+			format[y] = v.Format[src]
+			wrapped[y] = v.LineWrapped[src]
+		}
+		wrapped[0] = false
+		v.Content, v.Format, v.LineWrapped = content, format, wrapped
+		for y := 0; y < h; y++ {
+			if src := y - dy; src < 0 || src >= h {
+				v.eraseRegion(y, 0, y, v.Width-1)
+			}
+		}
+
+		if v.maxY >= 0 {
+			v.maxY += dy
+			if v.maxY >= h {
+				v.maxY = h - 1
+			}
+			if v.maxY < 0 {
+				v.maxY = -1
+			}
+		}
+	}
+
+	if dx != 0 {
+		w := v.Width
+		for y := range v.Content {
+			row, rowF := v.Content[y], v.Format[y]
+			switch {
+			case dx >= w || -dx >= w:
+				v.eraseRegion(y, 0, y, w-1)
+			case dx > 0:
+				copy(row[dx:], row[:w-dx])
+				copy(rowF[dx:], rowF[:w-dx])
+				v.eraseRegion(y, 0, y, dx-1)
+			default:
+				copy(row, row[-dx:])
+				copy(rowF, rowF[-dx:])
+				v.eraseRegion(y, w+dx, y, w-1)
+			}
+			// Rows no longer line up with the edges they wrapped at.
+			v.LineWrapped[y] = false
+		}
+	}
 }
 
 func (v *VT100) Resize(h, w int) {
 	v.mut.Lock()
 	defer v.mut.Unlock()
+	if h == v.Height && w == v.Width {
+		return
+	}
 	v.resize(h, w)
+	v.notify()
+}
+
+// ResizeKeepingTail is like Resize, but when it makes the terminal shorter it
+// drops rows from the top rather than the bottom, as far as is needed to keep
+// the cursor's row. This keeps the most recent output, such as the tail of a
+// log. The rows dropped go to the scrollback, if there is one.
+func (v *VT100) ResizeKeepingTail(h, w int) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if h == v.Height && w == v.Width {
+		return
+	}
+
+	v.keepTail(h)
+	v.resize(h, w)
+	v.notify()
+}
+
+// ResizeAnchored is like ResizeKeepingTail, but keeps the cursor on the same
+// cell of the content, not just on the same row. When the width changes, the
+// lines are re-wrapped to fit, as with Reflow, and the cursor moves along with
+// its cell. This suits mirroring a PTY, where the program goes on writing
+// wherever it thinks the cursor is.
+func (v *VT100) ResizeAnchored(h, w int) {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	if h == v.Height && w == v.Width {
+		return
+	}
+
+	if w != v.Width {
+		v.reflow(w)
+	}
+	v.keepTail(h)
+	v.resize(h, w)
+	v.notify()
+}
+
+// keepTail drops as many rows from the top as are needed to keep the cursor's
+// row once the terminal is made h rows tall.
+func (v *VT100) keepTail(h int) {
+	drop := v.Cursor.Y - (h - 1)
+	if drop > v.Height-h {
+		drop = v.Height - h
+	}
+	if drop > 0 {
+		v.dropTop(drop)
+	}
+}
+
+// dropTop removes the top n rows of the terminal, moving everything else up
+// and making it shorter.
+func (v *VT100) dropTop(n int) {
+	for y := 0; y < n; y++ {
+		v.scrollOff(y)
+	}
+
+	v.Content = v.Content[n:]
+	v.Format = v.Format[n:]
+	v.LineWrapped = v.LineWrapped[n:]
+	if len(v.LineWrapped) > 0 {
+		v.LineWrapped[0] = false
+	}
+	v.Height -= n
+	v.Cursor.Y -= n
+	if v.wrappedTo -= n; v.wrappedTo < 0 {
+		v.wrappedTo = 0
+	}
+	v.maxY -= n
+	if v.maxY < -1 {
+		v.maxY = -1
+	}
+
+	if v.damage != nil {
+		v.damage.Resized = true
+		v.damage.touch(0, v.Height-1)
+	}
 }
 
 func (v *VT100) resize(h, w int) {
+	// The cursor may have wrapped onto a row that's about to be added.
+	pending := v.wrappedTo > 0 && v.wrappedTo == v.Height && v.Cursor.Y == v.Height
+	v.wrappedTo = 0
+	if v.damage != nil && (h != v.Height || w != v.Width) {
+		v.damage.Resized = true
+	}
+
+	if v.Reflow && w != v.Width {
+		v.reflow(w)
+		pending = false
+	}
+
 	if h > v.Height {
 		n := h - v.Height
 		for row := 0; row < n; row++ {
 			v.Content = append(v.Content, make([]rune, v.Width))
 			v.Format = append(v.Format, make([]Format, v.Width))
+			v.LineWrapped = append(v.LineWrapped, row == 0 && pending)
 			for col := 0; col < v.Width; col++ {
 				v.clear(v.Height+row, col)
 			}
@@ -204,10 +1114,14 @@ func (v *VT100) resize(h, w int) {
 	} else if h < v.Height {
 		v.Content = v.Content[:h]
 		v.Format = v.Format[:h]
+		v.LineWrapped = v.LineWrapped[:h]
 		v.Height = h
+		if v.Cursor.Y >= h {
+			v.Cursor.Y = h - 1
+		}
 	}
 
-	if h < v.maxY {
+	if v.maxY >= h {
 		v.maxY = h - 1
 	}
 
@@ -232,64 +1146,580 @@ func (v *VT100) resize(h, w int) {
 		v.Width = w
 	}
 
-	if v.Cursor.X >= v.Width {
-		v.Cursor.X = v.Width - 1
+	if v.Cursor.X >= v.Width && !v.AutoResizeX {
+		v.Cursor.X = v.Width - 1
+	}
+
+	v.LeftMargin, v.RightMargin = 0, v.Width-1
+	v.TopMargin, v.BottomMargin = 0, v.Height-1
+	v.regionWrap = false
+}
+
+// reflow re-wraps the terminal's lines at width w, keeping its height. If
+// there are then too many rows, the ones below the cursor are dropped, and
+// then the ones at the top, which go to the scrollback.
+func (v *VT100) reflow(w int) {
+	// Join the rows into the lines that were wrapped to make them. Blanks at
+	// the end of a line are dropped, rather than wrapped.
+	type line struct {
+		content []rune
+		format  []Format
+	}
+	var lines []line
+	var cur line
+	cursorLine, cursorOffset := 0, 0
+	for y := 0; y < v.Height; y++ {
+		if y == v.Cursor.Y {
+			cursorLine, cursorOffset = len(lines), len(cur.content)+v.Cursor.X
+		}
+		cur.content = append(cur.content, v.Content[y]...)
+		cur.format = append(cur.format, v.Format[y]...)
+		if y < v.Height-1 && v.LineWrapped[y+1] {
+			continue
+		}
+
+		n := len(cur.content)
+		for n > 0 && cur.content[n-1] == ' ' && cur.format[n-1] == v.DefaultFormat {
+			n--
+		}
+		lines = append(lines, line{cur.content[:n], cur.format[:n]})
+		cur = line{}
+	}
+	if v.Cursor.Y >= v.Height {
+		cursorLine, cursorOffset = len(lines), 0
+	}
+
+	// Wrap them again at the new width.
+	var content [][]rune
+	var format [][]Format
+	var wrapped []bool
+	cursorY, cursorX, used := 0, 0, 0
+	for i, l := range lines {
+		rows := (len(l.content) + w - 1) / w
+		if i == cursorLine {
+			cursorY, cursorX = len(content)+cursorOffset/w, cursorOffset%w
+			if cursorOffset/w >= rows {
+				rows = cursorOffset/w + 1
+			}
+		}
+		if rows == 0 {
+			rows = 1
+		}
+		if len(l.content) > 0 {
+			used = len(content) + rows
+		}
+
+		for r := 0; r < rows; r++ {
+			row := make([]rune, w)
+			rowF := make([]Format, w)
+			for x := range row {
+				row[x] = ' '
+				rowF[x] = v.DefaultFormat
+			}
+			if start := r * w; start < len(l.content) {
+				end := start + w
+				if end > len(l.content) {
+					end = len(l.content)
+				}
+				copy(row, l.content[start:end])
+				copy(rowF, l.format[start:end])
+			}
+			content = append(content, row)
+			format = append(format, rowF)
+			wrapped = append(wrapped, r > 0)
+		}
+	}
+	if cursorLine == len(lines) {
+		cursorY, cursorX = len(content), 0
+	}
+
+	// Rows below the cursor go first, as with ResizeKeepingTail, then rows
+	// from the top go to the scrollback.
+	if extra := len(content) - v.Height; extra > 0 {
+		below := len(content) - 1 - cursorY
+		if below > extra {
+			below = extra
+		} else if below < 0 {
+			below = 0
+		}
+		n := len(content) - below
+		content, format, wrapped = content[:n], format[:n], wrapped[:n]
+	}
+	v.Content, v.Format, v.LineWrapped = content, format, wrapped
+	v.Width = w
+	if drop := len(content) - v.Height; drop > 0 {
+		for y := 0; y < drop; y++ {
+			v.scrollOff(y)
+		}
+		v.Content, v.Format, v.LineWrapped = content[drop:], format[drop:], wrapped[drop:]
+		if len(v.LineWrapped) > 0 {
+			v.LineWrapped[0] = false
+		}
+		cursorY -= drop
+		used -= drop
+	}
+
+	// Make up the rest of the height.
+	h := v.Height
+	v.Height = len(v.Content)
+	v.resize(h, w)
+
+	v.Cursor.Y, v.Cursor.X = cursorY, cursorX
+	if v.maxY >= 0 {
+		v.maxY = used - 1
+		if v.maxY < v.Cursor.Y {
+			v.maxY = v.Cursor.Y
+		}
+		if v.maxY >= v.Height {
+			v.maxY = v.Height - 1
+		}
+	}
+}
+
+func (v *VT100) Write(dt []byte) (int, error) {
+	v.mut.Lock()
+	defer v.unlock()
+	return v.write(dt)
+}
+
+// Damage describes the parts of a terminal affected by a write.
+type Damage struct {
+	// Top and Bottom are the first and last rows, inclusive, in which any
+	// cell was written or erased. They are both -1 if none were.
+	//
+	// Scrolling moves every row, so when the screen scrolls the range covers
+	// all of it.
+	Top, Bottom int
+
+	// Scrolled is the number of lines that the screen scrolled up by.
+	Scrolled int
+
+	// Resized is set if the dimensions of the terminal changed.
+	Resized bool
+}
+
+// touch records that the rows from y1 to y2, inclusive, were affected. It is
+// a no-op on a nil Damage so callers needn't check whether damage is being
+// tracked.
+func (d *Damage) touch(y1, y2 int) {
+	if d == nil {
+		return
+	}
+	if d.Top == -1 || y1 < d.Top {
+		d.Top = y1
+	}
+	if y2 > d.Bottom {
+		d.Bottom = y2
+	}
+}
+
+// WriteWithDamage is like Write, but additionally reports which parts of the
+// terminal the write affected.
+func (v *VT100) WriteWithDamage(dt []byte) (int, Damage, error) {
+	v.mut.Lock()
+	defer v.unlock()
+
+	d := Damage{Top: -1, Bottom: -1}
+	v.damage = &d
+	n, err := v.write(dt)
+	v.damage = nil
+	return n, d, err
+}
+
+// WriteCounting is like Write, but additionally reports how many cells the
+// write changed, whether by printing, erasing, or scrolling. Cells whose rune
+// and format end up the same as before aren't counted, and neither are cells
+// that were added or removed by a resize.
+func (v *VT100) WriteCounting(dt []byte) (int, int, error) {
+	v.mut.Lock()
+	defer v.unlock()
+
+	before := v.snapshot()
+	n, err := v.write(dt)
+	return n, v.changedCells(before), err
+}
+
+// changedCells counts the cells within both s and v whose rune or format
+// differs between them.
+func (v *VT100) changedCells(s *Snapshot) int {
+	var n int
+	for y := 0; y < len(s.Content) && y < v.Height; y++ {
+		for x := 0; x < len(s.Content[y]) && x < v.Width; x++ {
+			if s.Content[y][x] != v.Content[y][x] || s.Format[y][x] != v.Format[y][x] {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (v *VT100) write(dt []byte) (int, error) {
+	var changed bool
+	defer func() {
+		if changed {
+			v.notify()
+		}
+	}()
+
+	n := len(dt)
+	for len(dt) > 0 {
+		// Put runs of plain text straight onto the terminal, rather than
+		// decoding a Command for each rune.
+		if v.parser.ground() && v.EventLog == nil {
+			if l := v.putText(dt); l > 0 {
+				if v.Metrics != nil {
+					v.metrics.commands += utf8.RuneCount(dt[:l])
+				}
+				dt = dt[l:]
+				changed = true
+				continue
+			}
+		}
+
+		// Commands can change the mode, so it's synced before each one.
+		v.syncParser(&v.parser)
+		cmd, l, err := v.parser.step(dt)
+		dt = dt[l:]
+		if err != nil {
+			v.debug(err, "parse")
+		}
+		if cmd == nil {
+			continue
+		}
+
+		changed = true
+		if err := v.display(cmd); err != nil {
+			v.debug(err, "command")
+		}
+	}
+	if v.Metrics != nil {
+		v.metrics.bytes += n
+	}
+	return n, nil
+}
+
+// syncParser gives p the limits on the sequences written to v, and its
+// VT52Mode. It must be called with v.mut held.
+func (v *VT100) syncParser(p *Parser) {
+	p.MaxStringLength = v.MaxOSCLength
+	p.MaxParams = v.MaxParams
+	p.VT52Mode = v.VT52Mode
+}
+
+// DebugEvent describes an error found while writing to a VT100.
+type DebugEvent struct {
+	// Err is the error.
+	Err error
+
+	// Raw is the sequence that caused the error, as it was written. Only the
+	// start of a very long one is kept.
+	Raw []byte
+
+	// Kind is the Kind of an UnsupportedError. For other errors, it's
+	// "parse" if the input was malformed, or "command" if a command failed.
+	Kind string
+
+	// Y and X are the position of the cursor after the error.
+	Y, X int
+}
+
+// debug reports err, which came from the sequence just parsed, to DebugFunc
+// or DebugLogs.
+func (v *VT100) debug(err error, kind string) {
+	if v.DebugFunc == nil && v.DebugLogs == nil {
+		return
+	}
+
+	raw := append([]byte(nil), v.parser.raw...)
+	var ue UnsupportedError
+	if errors.As(err, &ue) {
+		ue.Raw = raw
+		err, kind = ue, ue.Kind
+	}
+
+	if v.DebugFunc != nil {
+		v.DebugFunc(DebugEvent{
+			Err:  err,
+			Raw:  raw,
+			Kind: kind,
+			Y:    v.Cursor.Y,
+			X:    v.Cursor.X,
+		})
+		return
+	}
+
+	if kind == "parse" {
+		fmt.Fprintln(v.DebugLogs, err)
+	} else {
+		fmt.Fprintf(v.DebugLogs, "%s\n%s", err, hex.Dump(raw))
+	}
+}
+
+// display carries out c, counting it for Metrics.
+func (v *VT100) display(c Command) error {
+	err := c.display(v)
+	if v.EventLog != nil {
+		v.logEvent(c, err)
+	}
+	if v.Metrics == nil {
+		return err
+	}
+
+	v.metrics.commands++
+	var ue UnsupportedError
+	if errors.As(err, &ue) {
+		v.metrics.unsupported = append(v.metrics.unsupported, ue.Kind)
+	}
+	return err
+}
+
+// Process handles a single ANSI terminal command, updating the terminal
+// appropriately.
+//
+// One special kind of error that this can return is an UnsupportedError. It's
+// probably best to check for these and skip, because they are likely recoverable.
+// Support errors are counted by Metrics, so it is possibly not necessary to log
+// them. If you want to check what's failed, set Metrics to ExpvarMetrics, start a
+// debug http server and examine the vt100-unsupported-commands field in
+// /debug/vars.
+func (v *VT100) Process(c Command) error {
+	v.mut.Lock()
+	defer v.unlock()
+	defer v.notify()
+
+	err := v.display(c)
+	if ue, ok := err.(UnsupportedError); ok {
+		var raw bytes.Buffer
+		c.Encode(&raw)
+		ue.Raw = raw.Bytes()
+		err = ue
+	}
+	return err
+}
+
+// ProcessAll handles each of cmds in turn, like Process, but without letting
+// anything else see the terminal until all of them have been handled. Every
+// error is returned, joined together.
+func (v *VT100) ProcessAll(cmds []Command) error {
+	v.mut.Lock()
+	defer v.unlock()
+	defer v.notify()
+
+	var errs []error
+	for _, c := range cmds {
+		if err := v.display(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ProcessString decodes s and handles all of its commands, like ProcessAll.
+// Unlike writing s, all errors are returned, joined together, rather than
+// logged.
+func (v *VT100) ProcessString(s string) error {
+	v.mut.Lock()
+	defer v.unlock()
+	defer v.notify()
+
+	var errs []error
+	var p Parser
+	for data := []byte(s); len(data) > 0; {
+		v.syncParser(&p)
+		cmd, n, err := p.step(data)
+		data = data[n:]
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if cmd == nil {
+			continue
+		}
+		if err := v.display(cmd); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !p.ground() {
+		errs = append(errs, io.ErrUnexpectedEOF)
+	}
+	return errors.Join(errs...)
+}
+
+// unlock releases v.mut, then calls OnFrame for any synchronized updates that
+// completed while it was held, and reports to Metrics.
+func (v *VT100) unlock() {
+	frames, onFrame := v.pendingFrames, v.OnFrame
+	v.pendingFrames = 0
+	counts, metrics := v.metrics, v.Metrics
+	v.metrics = metricCounts{}
+	v.invalidateHash()
+	v.mut.Unlock()
+
+	if metrics != nil {
+		counts.report(metrics)
+	}
+	if onFrame == nil {
+		return
+	}
+	for i := 0; i < frames; i++ {
+		onFrame()
+	}
+}
+
+// Region copies the rectangle from (y1, x1) to (y2, x2), inclusive, into a
+// new terminal of that size. The new terminal shares no memory with v, and
+// its cursor starts at 0, 0.
+//
+// Coordinates past the edges of the terminal are clamped to it. An error is
+// returned if the rectangle is inverted or lies entirely outside of v.
+func (v *VT100) Region(y1, x1, y2, x2 int) (*VT100, error) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	if y1 > y2 || x1 > x2 {
+		return nil, fmt.Errorf("invalid region (%d, %d)-(%d, %d)", y1, x1, y2, x2)
+	}
+	if y2 < 0 || x2 < 0 || y1 >= v.Height || x1 >= v.Width {
+		return nil, fmt.Errorf("region (%d, %d)-(%d, %d) out of bounds (%d, %d)", y1, x1, y2, x2, v.Height, v.Width)
+	}
+
+	y1, x1, _ = sanitize(v, y1, x1)
+	y2, x2, _ = sanitize(v, y2, x2)
+
+	r := NewVT100(y2-y1+1, x2-x1+1)
+	for y := y1; y <= y2; y++ {
+		copy(r.Content[y-y1], v.Content[y][x1:x2+1])
+		copy(r.Format[y-y1], v.Format[y][x1:x2+1])
+	}
+	if x1 == 0 && x2 == v.Width-1 {
+		copy(r.LineWrapped[1:], v.LineWrapped[y1+1:y2+1])
+	}
+	if v.maxY >= y1 {
+		r.maxY = v.maxY - y1
+		if r.maxY >= r.Height {
+			r.maxY = r.Height - 1
+		}
+	}
+
+	return r, nil
+}
+
+// Snapshot is a copy of the state of a terminal at some point in time. It
+// shares no memory with the terminal, so it is safe to inspect while the
+// terminal is being written to.
+type Snapshot struct {
+	Height, Width int
+	Content       [][]rune
+	Format        [][]Format
+	Cursor        Cursor
+}
+
+// Snapshot returns a copy of the current state of the terminal.
+func (v *VT100) Snapshot() *Snapshot {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+	return v.snapshot()
+}
+
+func (v *VT100) snapshot() *Snapshot {
+	s := &Snapshot{
+		Height:  v.Height,
+		Width:   v.Width,
+		Content: make([][]rune, len(v.Content)),
+		Format:  make([][]Format, len(v.Format)),
+		Cursor:  v.Cursor,
+	}
+	for y := range v.Content {
+		s.Content[y] = append([]rune(nil), v.Content[y]...)
+		s.Format[y] = append([]Format(nil), v.Format[y]...)
+	}
+	return s
+}
+
+// ContentCopy returns a copy of Content.
+func (v *VT100) ContentCopy() [][]rune {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	content := make([][]rune, len(v.Content))
+	for y := range v.Content {
+		content[y] = append([]rune(nil), v.Content[y]...)
 	}
+	return content
 }
 
-func (v *VT100) Write(dt []byte) (int, error) {
-	v.mut.Lock()
-	defer v.mut.Unlock()
+// FormatCopy returns a copy of Format.
+func (v *VT100) FormatCopy() [][]Format {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
 
-	n := len(dt)
-	if len(v.unparsed) > 0 {
-		dt = append(v.unparsed, dt...) // this almost never happens
-		v.unparsed = nil
+	format := make([][]Format, len(v.Format))
+	for y := range v.Format {
+		format[y] = append([]Format(nil), v.Format[y]...)
 	}
-	buf := bytes.NewBuffer(dt)
-	for {
-		if buf.Len() == 0 {
-			return n, nil
-		}
-		cmd, err := Decode(buf)
-		if err != nil {
-			if l := buf.Len(); l > 0 && l < 12 { // on small leftover handle unparsed, otherwise skip
-				v.unparsed = buf.Bytes()
-			}
-			return n, nil
-		}
+	return format
+}
+
+// ContentEqual reports whether v and other have the same dimensions, and the
+// same runes and formats in every cell. The cursor and modes of the terminals
+// aren't compared.
+func (v *VT100) ContentEqual(other *VT100) bool {
+	if v == other {
+		return true
+	}
+
+	// Always lock the two terminals in the same order, so that comparisons
+	// running the other way around can't deadlock with this one.
+	first, second := v, other
+	if uintptr(unsafe.Pointer(first)) > uintptr(unsafe.Pointer(second)) {
+		first, second = second, first
+	}
+	first.mut.RLock()
+	defer first.mut.RUnlock()
+	second.mut.RLock()
+	defer second.mut.RUnlock()
 
-		if err := cmd.display(v); err != nil {
-			if v.DebugLogs != nil {
-				fmt.Fprintln(v.DebugLogs, err)
+	if v.Height != other.Height || v.Width != other.Width {
+		return false
+	}
+	for y := range v.Content {
+		for x := range v.Content[y] {
+			if v.Content[y][x] != other.Content[y][x] || v.Format[y][x] != other.Format[y][x] {
+				return false
 			}
 		}
 	}
+	return true
 }
 
-// Process handles a single ANSI terminal command, updating the terminal
-// appropriately.
-//
-// One special kind of error that this can return is an UnsupportedError. It's
-// probably best to check for these and skip, because they are likely recoverable.
-// Support errors are exported as expvars, so it is possibly not necessary to log
-// them. If you want to check what's failed, start a debug http server and examine
-// the vt100-unsupported-commands field in /debug/vars.
-func (v *VT100) Process(c Command) error {
-	v.mut.Lock()
-	defer v.mut.Unlock()
-
-	return c.display(v)
+// HTMLOptions changes how HTMLWithOptions renders a terminal.
+type HTMLOptions struct {
+	// BlinkClasses marks blinking text with the class "blink", or
+	// "rapid-blink" for SGR 6, rather than styling it inline, so that a
+	// stylesheet can animate it.
+	BlinkClasses bool
 }
 
 // HTML renders v as an HTML fragment. One idea for how to use this is to debug
 // the current state of the screen reader.
 func (v *VT100) HTML() string {
-	v.mut.Lock()
-	defer v.mut.Unlock()
+	return v.HTMLWithOptions(HTMLOptions{})
+}
+
+// HTMLWithOptions is like HTML, but renders v as opts says.
+func (v *VT100) HTMLWithOptions(opts HTMLOptions) string {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
 
 	var buf bytes.Buffer
-	buf.WriteString(`<pre style="color:white;background-color:black;">`)
+	defFg, defBg := v.defaultColors()
+	preFg, preBg := "white", "black"
+	if v.DefaultFg != nil {
+		preFg = toCss(defFg)
+	}
+	if v.DefaultBg != nil {
+		preBg = toCss(defBg)
+	}
+	buf.WriteString(`<pre style="color:` + preFg + `;background-color:` + preBg + `;">`)
 
 	// Iterate each row. When the css changes, close the previous span, and open
 	// a new one. No need to close a span when the css is empty, we won't have
@@ -303,7 +1733,11 @@ func (v *VT100) HTML() string {
 					buf.WriteString("</span>")
 				}
 				if f != (Format{}) {
-					buf.WriteString(`<span style="` + f.css() + `">`)
+					buf.WriteString(`<span `)
+					if class := f.blinkClass(); opts.BlinkClasses && class != "" {
+						buf.WriteString(`class="` + class + `" `)
+					}
+					buf.WriteString(`style="` + f.css(opts.BlinkClasses, defFg, defBg) + `">`)
 				}
 				lastFormat = f
 			}
@@ -320,10 +1754,153 @@ func (v *VT100) HTML() string {
 	return buf.String()
 }
 
+// DebugDump returns the text of the terminal, one line per row, with changes
+// of format marked inline by tokens such as "{bold,fg=red}" and "{/}". The
+// tokens are always generated the same way for the same format, and each row
+// ends with the default format, so the output suits golden files.
+func (v *VT100) DebugDump() string {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	var buf bytes.Buffer
+	for y, row := range v.Content {
+		var last string
+		for x, r := range row {
+			if tokens := v.Format[y][x].tokens(); tokens != last {
+				if last != "" {
+					buf.WriteString("{/}")
+				}
+				if tokens != "" {
+					buf.WriteString("{" + tokens + "}")
+				}
+				last = tokens
+			}
+			buf.WriteRune(r)
+		}
+		if last != "" {
+			buf.WriteString("{/}")
+		}
+		buf.WriteRune('\n')
+	}
+	return buf.String()
+}
+
+// String describes f by the same tokens as DebugDump, e.g. "bold,fg=red",
+// along with "reset" if Reset is set. The default format is "".
+func (f Format) String() string {
+	tokens := f.tokens()
+	if f.Reset {
+		if tokens == "" {
+			return "reset"
+		}
+		return "reset," + tokens
+	}
+	return tokens
+}
+
+// tokens describes f for DebugDump, as a sorted, comma-separated list of its
+// attributes. It's empty for the default format.
+func (f Format) tokens() string {
+	var parts []string
+	if f.Fg != nil {
+		parts = append(parts, "fg="+colorName(f.Fg))
+	}
+	if f.Bg != nil {
+		parts = append(parts, "bg="+colorName(f.Bg))
+	}
+	switch f.Intensity {
+	case Bold:
+		parts = append(parts, "bold")
+	case Faint:
+		parts = append(parts, "faint")
+	}
+	if f.UnderlineStyle != NoUnderline {
+		parts = append(parts, "underline="+underlineStyleNames[f.UnderlineStyle])
+	} else if f.Underline {
+		parts = append(parts, "underline")
+	}
+	if f.UnderlineColor != nil {
+		parts = append(parts, "ul="+colorName(f.UnderlineColor))
+	}
+	for _, attr := range []struct {
+		set  bool
+		name string
+	}{
+		{f.Italic, "italic"},
+		{f.Blink, "blink"},
+		{f.RapidBlink, "rapidblink"},
+		{f.Reverse, "reverse"},
+		{f.Conceal, "conceal"},
+		{f.CrossOut, "crossout"},
+		{f.Overline, "overline"},
+		{f.Protected, "protected"},
+	} {
+		if attr.set {
+			parts = append(parts, attr.name)
+		}
+	}
+
+	// As in css, sorting means that the same attributes are always
+	// described the same way.
+	sort.StringSlice(parts).Sort()
+
+	return strings.Join(parts, ",")
+}
+
+// underlineStyleNames are the names of the UnderlineStyles.
+var underlineStyleNames = map[UnderlineStyle]string{
+	SingleUnderline: "single",
+	DoubleUnderline: "double",
+	CurlyUnderline:  "curly",
+	DottedUnderline: "dotted",
+	DashedUnderline: "dashed",
+}
+
+// ansiColorNames are the names of the 16 ANSI colors.
+var ansiColorNames = [...]string{
+	"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white",
+	"brightblack", "brightred", "brightgreen", "brightyellow", "brightblue", "brightmagenta", "brightcyan", "brightwhite",
+}
+
+// colorName names c for DebugDump.
+func colorName(c termenv.Color) string {
+	switch c := c.(type) {
+	case termenv.ANSIColor:
+		if c >= 0 && int(c) < len(ansiColorNames) {
+			return ansiColorNames[c]
+		}
+		return fmt.Sprintf("ansi%d", int(c))
+	case termenv.ANSI256Color:
+		return fmt.Sprintf("%d", int(c))
+	default:
+		return toCss(c)
+	}
+}
+
+// controlPictures holds the symbols U+2400 through U+241F, which stand for the
+// C0 control characters. Each is 3 bytes long in UTF-8.
+const controlPictures = "␀␁␂␃␄␅␆␇␈␉␊␋␌␍␎␏␐␑␒␓␔␕␖␗␘␙␚␛␜␝␞␟"
+
 // maybeEscapeRune potentially escapes a rune for display in an html document.
-// It only escapes the things that html.EscapeString does, but it works without allocating
+// It escapes the things that html.EscapeString does, but it works without allocating
 // a string to hold r. Returns an empty string if there is no need to escape.
+//
+// Control characters, which aren't allowed in HTML, are shown as the matching
+// symbol from the Control Pictures block where there is one, and as a numeric
+// reference to the replacement character otherwise, as are runes that aren't
+// valid at all.
 func maybeEscapeRune(r rune) string {
+	switch {
+	case !utf8.ValidRune(r):
+		return "&#xfffd;"
+	case r < 0x20:
+		return controlPictures[r*3 : r*3+3]
+	case r == 0x7f:
+		return "\u2421"
+	case r == utf8.RuneError || unicode.IsControl(r):
+		return "&#xfffd;"
+	}
+
 	switch r {
 	case '&':
 		return "&amp;"
@@ -339,48 +1916,239 @@ func maybeEscapeRune(r rune) string {
 	return ""
 }
 
+// putText puts the printable runes at the start of p onto the terminal, up to
+// the first control character or invalid UTF-8, and returns how many bytes of
+// p they took up. They're handled exactly as Decode and runeCommand would.
+func (v *VT100) putText(p []byte) int {
+	var n int
+	for n < len(p) {
+		r, size := rune(p[n]), 1
+		if r >= utf8.RuneSelf {
+			r, size = utf8.DecodeRune(p[n:])
+			if r == utf8.RuneError && size == 1 {
+				break
+			}
+		}
+		if unicode.IsControl(r) {
+			break
+		}
+		v.put(r)
+		n += size
+	}
+	return n
+}
+
 // put puts r onto the current cursor's position, then advances the cursor.
 func (v *VT100) put(r rune) {
+	if v.regionWrap {
+		v.regionWrap = false
+		scrolls := v.Cursor.Y == v.BottomMargin && v.BottomMargin > v.TopMargin
+		v.lineFeedInRegion()
+		if scrolls {
+			v.LineWrapped[v.Cursor.Y] = true
+		}
+	}
+	// Resize x first, since resizing y clamps the cursor to the old width.
+	v.resizeXIfNeeded()
+	if v.Cursor.X >= v.Width {
+		// It's past MaxWidth, and truncated.
+		return
+	}
+	// Scrolling or growing marks a wrap onto the new row, so wrappedTo is
+	// only forgotten after.
+	v.scrollOrResizeYIfNeeded()
+	v.wrappedTo = 0
 	if v.Cursor.Y > v.maxY {
 		// track max character offset for UsedHeight()
 		v.maxY = v.Cursor.Y
 	}
-
-	v.scrollOrResizeYIfNeeded()
-	v.resizeXIfNeeded()
+	charset := v.G0
+	if v.ShiftOut {
+		charset = v.G1
+	}
 	row := v.Content[v.Cursor.Y]
-	row[v.Cursor.X] = r
 	rowF := v.Format[v.Cursor.Y]
+	if v.InsertMode {
+		// Within the margins, what's pushed past the right one is lost, and
+		// the cells beyond it are left alone.
+		end := len(row)
+		if v.LeftRightMarginMode && v.Cursor.X <= v.RightMargin {
+			end = v.RightMargin + 1
+		}
+		copy(row[v.Cursor.X+1:end], row[v.Cursor.X:end])
+		copy(rowF[v.Cursor.X+1:end], rowF[v.Cursor.X:end])
+	}
+	row[v.Cursor.X] = charset.translate(r)
 	rowF[v.Cursor.X] = v.Cursor.F
+	v.damage.touch(v.Cursor.Y, v.Cursor.Y)
 	v.advance()
 }
 
 // advance advances the cursor, wrapping to the next line if need be.
 func (v *VT100) advance() {
 	v.Cursor.X++
-	if v.Cursor.X >= v.Width && !v.AutoResizeX {
+	if v.Cursor.X >= v.widthLimit() && !v.truncates() {
+		if v.partialRegion() && (v.Cursor.Y == v.BottomMargin || v.Cursor.Y >= v.Height-1) {
+			v.Cursor.X--
+			v.regionWrap = true
+			return
+		}
 		v.Cursor.X = 0
 		v.Cursor.Y++
+		v.wrappedTo = v.Cursor.Y
+		if v.Cursor.Y < v.Height {
+			v.LineWrapped[v.Cursor.Y] = true
+		}
+	}
+}
+
+// unwrap is called when the cursor is moved to the start of a line. The
+// cursor wraps as soon as the last column is written, so if it's still at the
+// start of the row it wrapped onto, the line really ended at the edge, and
+// the row isn't a continuation after all.
+func (v *VT100) unwrap() {
+	if v.wrappedTo > 0 && v.wrappedTo < v.Height && v.Cursor.Y == v.wrappedTo && v.Cursor.X == 0 {
+		v.LineWrapped[v.wrappedTo] = false
 	}
+	v.wrappedTo = 0
+	v.regionWrap = false
 }
 
 func (v *VT100) resizeXIfNeeded() {
 	if v.AutoResizeX && v.Cursor.X+1 >= v.Width {
-		v.resize(v.Height, v.Cursor.X+1)
+		w := v.Cursor.X + 1
+		if w > v.widthLimit() {
+			w = v.widthLimit()
+		}
+		if w > v.Width {
+			v.resize(v.Height, w)
+		}
+	}
+}
+
+// maxGrowth is the most rows or columns past the edge of a terminal that
+// the cursor can reach when there's no MaxHeight or MaxWidth to stop it. It
+// keeps a single sequence, such as a CUP to row 99999, from making the
+// terminal enormous.
+const maxGrowth = 1000
+
+// widthLimit returns the number of columns the cursor can reach, growing the
+// terminal as needed: its width, unless it grows to fit with AutoResizeX.
+func (v *VT100) widthLimit() int {
+	switch {
+	case !v.AutoResizeX:
+		return v.Width
+	case v.MaxWidth > 0 && v.MaxWidth >= v.Width:
+		return v.MaxWidth
+	case v.MaxWidth > 0:
+		return v.Width
+	default:
+		return v.Width + maxGrowth
 	}
 }
 
+// truncates reports whether text past the right edge of a terminal that's as
+// wide as it can grow is dropped, rather than wrapped.
+func (v *VT100) truncates() bool {
+	return v.AutoResizeX && v.MaxWidth > 0 && v.TruncateAtMaxWidth
+}
+
+// OverflowBehavior is what a VT100 does when its content runs past the bottom
+// of the terminal.
+type OverflowBehavior int
+
+const (
+	// OverflowScroll scrolls the content up, losing the top line.
+	OverflowScroll OverflowBehavior = iota
+	// OverflowResizeY makes the terminal taller, like AutoResizeY.
+	OverflowResizeY
+	// OverflowClamp keeps the cursor on the last line, which is overwritten.
+	OverflowClamp
+)
+
 func (v *VT100) scrollOrResizeYIfNeeded() {
 	if v.Cursor.Y >= v.Height {
-		if v.AutoResizeY {
+		switch {
+		case v.resizesY() && v.Cursor.Y < v.heightLimit():
 			v.resize(v.Cursor.Y+1, v.Width)
-		} else {
+		case v.Overflow == OverflowClamp:
+			v.Cursor.Y = v.Height - 1
+		default:
 			v.scrollOne()
 		}
 	}
 }
 
+// scrollbackLine is a line kept in the scrollback.
+type scrollbackLine struct {
+	content []rune
+	format  []Format
+}
+
+// LinesScrolled returns the number of rows that have scrolled off the top of
+// the terminal, whether or not they were kept in the scrollback, since it was
+// made or the scrollback was last erased, by CSI 3 J or Reset.
+func (v *VT100) LinesScrolled() int {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+	return v.linesScrolled
+}
+
+// ScrollbackLen returns the number of lines in the scrollback.
+func (v *VT100) ScrollbackLen() int {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+	return len(v.scrollback)
+}
+
+// ScrollbackLine returns the text of line i of the scrollback, where 0 is
+// the oldest, along with the format of each of its cells.
+func (v *VT100) ScrollbackLine(i int) (string, []Format, error) {
+	v.mut.RLock()
+	defer v.mut.RUnlock()
+
+	if i < 0 || i >= len(v.scrollback) {
+		return "", nil, fmt.Errorf("scrollback line %d out of bounds (%d)", i, len(v.scrollback))
+	}
+
+	line := v.scrollback[i]
+	formats := make([]Format, len(line.format))
+	copy(formats, line.format)
+	return string(line.content), formats, nil
+}
+
+// pushScrollback keeps a copy of row y in the scrollback, if there is one,
+// and writes it to ScrollbackWriter, if that is set.
+func (v *VT100) pushScrollback(y int) {
+	if v.ScrollbackWriter != nil {
+		io.WriteString(v.ScrollbackWriter, string(v.Content[y][:v.trimmedWidth(y)])+"\n")
+	}
+	if v.scrollbackLimit <= 0 {
+		return
+	}
+	if len(v.scrollback) >= v.scrollbackLimit {
+		v.scrollback = v.scrollback[1:]
+	}
+	v.scrollback = append(v.scrollback, scrollbackLine{
+		content: append([]rune(nil), v.Content[y]...),
+		format:  append([]Format(nil), v.Format[y]...),
+	})
+}
+
+// scrollOff is called with each row y that leaves the top of the terminal,
+// before it's gone. It keeps the row in the scrollback, passes it to OnScroll,
+// and counts it.
+func (v *VT100) scrollOff(y int) {
+	v.pushScrollback(y)
+	if v.OnScroll != nil {
+		v.OnScroll(v.Content[y], v.Format[y])
+	}
+	v.linesScrolled++
+}
+
 func (v *VT100) scrollOne() {
+	v.scrollOff(0)
+
 	first := v.Content[0]
 	copy(v.Content, v.Content[1:])
 	for i := range first {
@@ -391,77 +2159,367 @@ func (v *VT100) scrollOne() {
 	firstF := v.Format[0]
 	copy(v.Format, v.Format[1:])
 	for i := range first {
-		firstF[i] = Format{}
+		firstF[i] = v.DefaultFormat
 	}
 	v.Format[v.Height-1] = firstF
 
+	copy(v.LineWrapped, v.LineWrapped[1:])
+	v.LineWrapped[0] = false
+	// The cursor may have wrapped onto the row that's just been added.
+	if v.Height > 1 {
+		v.LineWrapped[v.Height-1] = v.wrappedTo == v.Height && v.Cursor.Y == v.Height
+	}
+	v.wrappedTo = 0
+
+	if v.maxY >= 0 {
+		v.maxY--
+	}
 	v.Cursor.Y = v.Height - 1
+
+	if v.damage != nil {
+		v.damage.Scrolled++
+		v.damage.touch(0, v.Height-1)
+	}
+}
+
+// scroll moves the content of the terminal up by n lines, or down if n is
+// negative, without moving the cursor. Lines scrolled off the top go to the
+// scrollback, as usual, and those scrolled off the bottom are lost.
+func (v *VT100) scroll(n int) {
+	if v.partialRegion() {
+		rows := v.BottomMargin - v.TopMargin + 1
+		for i := 0; i < n && i < rows; i++ {
+			v.scrollRegion(true)
+		}
+		for i := 0; i > n && i > -rows; i-- {
+			v.scrollRegion(false)
+		}
+		return
+	}
+
+	y := v.Cursor.Y
+	for i := 0; i < n && i < v.Height; i++ {
+		v.scrollOne()
+	}
+	for i := 0; i > n && i > -v.Height; i-- {
+		v.scrollDownOne()
+	}
+	v.Cursor.Y = y
+}
+
+// scrollDownOne moves the content of the terminal down by a line, leaving a
+// blank one at the top.
+func (v *VT100) scrollDownOne() {
+	last := v.Content[v.Height-1]
+	copy(v.Content[1:], v.Content)
+	for i := range last {
+		last[i] = ' '
+	}
+	v.Content[0] = last
+
+	lastF := v.Format[v.Height-1]
+	copy(v.Format[1:], v.Format)
+	for i := range lastF {
+		lastF[i] = v.DefaultFormat
+	}
+	v.Format[0] = lastF
+
+	copy(v.LineWrapped[1:], v.LineWrapped)
+	v.LineWrapped[0] = false
+	if v.Height > 1 {
+		v.LineWrapped[1] = false
+	}
+	v.wrappedTo = 0
+
+	if v.maxY >= 0 && v.maxY < v.Height-1 {
+		v.maxY++
+	}
+	v.damage.touch(0, v.Height-1)
+}
+
+// partialRegion reports whether the top and bottom margins leave out any of
+// the screen, so that scrolling is confined between them.
+func (v *VT100) partialRegion() bool {
+	return v.TopMargin > 0 || v.BottomMargin < v.Height-1
+}
+
+// lineFeedInRegion moves the cursor to the start of the next row when there's
+// a partial scrolling region. At the bottom margin, the region scrolls up
+// instead, and at the bottom of the screen, the cursor stays on its row.
+func (v *VT100) lineFeedInRegion() {
+	switch {
+	case v.Cursor.Y == v.BottomMargin:
+		v.scrollRegion(true)
+	case v.Cursor.Y < v.Height-1:
+		v.Cursor.Y++
+	default:
+		v.Cursor.Y = v.Height - 1
+	}
+	v.Cursor.X = 0
+}
+
+// scrollRegion moves the rows between the top and bottom margins up a line,
+// or down if up isn't set, leaving a blank row in their place. The row
+// scrolled out of the region is lost.
+func (v *VT100) scrollRegion(up bool) {
+	top, bottom := v.TopMargin, v.BottomMargin+1
+	rows, rowsF, wrapped := v.Content[top:bottom], v.Format[top:bottom], v.LineWrapped[top:bottom]
+	last := len(rows) - 1
+
+	var row []rune
+	var rowF []Format
+	if up {
+		row, rowF = rows[0], rowsF[0]
+		copy(rows, rows[1:])
+		copy(rowsF, rowsF[1:])
+		copy(wrapped, wrapped[1:])
+		rows[last], rowsF[last], wrapped[last] = row, rowF, false
+	} else {
+		row, rowF = rows[last], rowsF[last]
+		copy(rows[1:], rows)
+		copy(rowsF[1:], rowsF)
+		copy(wrapped[1:], wrapped)
+		rows[0], rowsF[0] = row, rowF
+		if last > 0 {
+			wrapped[1] = false
+		}
+	}
+	// Rows at the edges of the region no longer continue the ones that
+	// were next to them.
+	wrapped[0] = false
+	if bottom < v.Height {
+		v.LineWrapped[bottom] = false
+	}
+	for i := range row {
+		row[i] = ' '
+		rowF[i] = v.DefaultFormat
+	}
+	v.wrappedTo = 0
+	v.damage.touch(top, bottom-1)
+}
+
+// originX translates a column relative to the origin into an absolute one.
+// In origin mode, columns are relative to the left margin and can't pass the
+// right margin.
+func (v *VT100) originX(x int) int {
+	if !v.OriginMode || !v.LeftRightMarginMode {
+		return x
+	}
+	x += v.LeftMargin
+	if x > v.RightMargin {
+		x = v.RightMargin
+	}
+	return x
+}
+
+// originY translates a row relative to the origin into an absolute one. In
+// origin mode, rows are relative to the top margin and can't pass the bottom
+// margin.
+func (v *VT100) originY(y int) int {
+	if !v.OriginMode {
+		return y
+	}
+	y += v.TopMargin
+	if y > v.BottomMargin {
+		y = v.BottomMargin
+	}
+	return y
+}
+
+// stopAtMargins clamps the target x of horizontal cursor motion to the left
+// and right margins, if they are set and the cursor starts within them.
+func (v *VT100) stopAtMargins(x int) int {
+	if !v.LeftRightMarginMode {
+		return x
+	}
+	if x < v.LeftMargin && (v.OriginMode || v.Cursor.X >= v.LeftMargin) {
+		x = v.LeftMargin
+	}
+	if x > v.RightMargin && (v.OriginMode || v.Cursor.X <= v.RightMargin) {
+		x = v.RightMargin
+	}
+	return x
 }
 
-// home moves the cursor to the coordinates y x. If y x are out of bounds, v.Err
-// is set.
-func (v *VT100) home(y, x int) {
+// home moves the cursor to the coordinates y x. If they're out of bounds,
+// they're clamped to the edges of the terminal, and an error is returned.
+// The bottom and right edges don't count if the terminal resizes itself to
+// fit in that direction, as far as it can: it does so once something is
+// written there.
+func (v *VT100) home(y, x int) error {
+	v.regionWrap = false
+	var err error
+	if y < 0 || x < 0 || y >= v.heightLimit() || x >= v.widthLimit() {
+		err = fmt.Errorf("out of bounds (%d, %d)", y, x)
+	}
+
+	if y < 0 {
+		y = 0
+	} else if y >= v.heightLimit() {
+		y = v.heightLimit() - 1
+	}
+	if x < 0 {
+		x = 0
+	} else if x >= v.widthLimit() {
+		x = v.widthLimit() - 1
+	}
 	v.Cursor.Y, v.Cursor.X = y, x
+	return err
+}
+
+// resizesY reports whether the terminal grows taller, rather than scrolling,
+// when the cursor goes past the bottom.
+func (v *VT100) resizesY() bool {
+	return v.AutoResizeY || v.Overflow == OverflowResizeY
+}
+
+// heightLimit is like widthLimit, but for rows: it's the height, unless the
+// terminal grows taller to fit, up to MaxHeight.
+func (v *VT100) heightLimit() int {
+	switch {
+	case !v.resizesY():
+		return v.Height
+	case v.MaxHeight > 0 && v.MaxHeight >= v.Height:
+		return v.MaxHeight
+	case v.MaxHeight > 0:
+		return v.Height
+	default:
+		return v.Height + maxGrowth
+	}
+}
+
+// setColumnMode handles DECCOLM, which sets the width of the terminal to 132
+// columns, or back to 80, clearing the screen and homing the cursor.
+func (v *VT100) setColumnMode(wide bool) {
+	w := 80
+	if wide {
+		w = 132
+	}
+	if w != v.Width {
+		v.resize(v.Height, w)
+	}
+	v.LeftMargin, v.RightMargin = 0, v.Width-1
+	v.eraseRegion(0, 0, v.Height-1, v.Width-1)
+	v.home(0, 0)
 }
 
-// eraseDirection is the logical direction in which an erase command happens,
+// EraseDirection is the logical direction in which an erase command happens,
 // from the cursor. For both erase commands, forward is 0, backward is 1,
-// and everything is 2.
-type eraseDirection int
+// and everything is 2. Erasing lines can also erase the scrollback, with 3.
+type EraseDirection int
 
 const (
 	// From the cursor to the end, inclusive.
-	eraseForward eraseDirection = iota
+	EraseForward EraseDirection = iota
 
 	// From the beginning to the cursor, inclusive.
-	eraseBack
+	EraseBack
 
 	// Everything.
-	eraseAll
+	EraseAll
+
+	// Everything, including the scrollback.
+	EraseScrollback
 )
 
-// eraseColumns erases columns from the current line.
-func (v *VT100) eraseColumns(d eraseDirection) {
+// eraseColumns erases columns from the current line. A selective erase
+// leaves protected cells alone.
+func (v *VT100) eraseColumns(d EraseDirection, selective bool) {
+	erase := v.eraseRegion
+	if selective {
+		erase = v.selectiveEraseRegion
+	}
+
 	y, x := v.Cursor.Y, v.Cursor.X // Aliases for simplicity.
 	switch d {
-	case eraseBack:
-		v.eraseRegion(y, 0, y, x)
-	case eraseForward:
-		v.eraseRegion(y, x, y, v.Width-1)
-	case eraseAll:
-		v.eraseRegion(y, 0, y, v.Width-1)
+	case EraseBack:
+		erase(y, 0, y, x)
+	case EraseForward:
+		// Past the last column, there's nothing left to erase.
+		if x < v.Width {
+			erase(y, x, y, v.Width-1)
+		}
+	case EraseAll:
+		erase(y, 0, y, v.Width-1)
 	}
 }
 
 // eraseLines erases lines from the current terminal. Note that
 // no matter what is selected, the entire current line is erased.
-func (v *VT100) eraseLines(d eraseDirection) {
+// A selective erase leaves protected cells alone.
+func (v *VT100) eraseLines(d EraseDirection, selective bool) {
+	erase := v.eraseRegion
+	if selective {
+		erase = v.selectiveEraseRegion
+	}
+
 	y := v.Cursor.Y // Alias for simplicity.
 	switch d {
-	case eraseBack:
-		v.eraseRegion(0, 0, y, v.Width-1)
-	case eraseForward:
-		v.eraseRegion(y, 0, v.Height-1, v.Width-1)
-	case eraseAll:
-		v.eraseRegion(0, 0, v.Height-1, v.Width-1)
+	case EraseBack:
+		erase(0, 0, y, v.Width-1)
+	case EraseForward:
+		erase(y, 0, v.Height-1, v.Width-1)
+	case EraseAll, EraseScrollback:
+		erase(0, 0, v.Height-1, v.Width-1)
+		if !selective {
+			// Nothing written is left.
+			v.maxY = -1
+		}
+	}
+	if d == EraseScrollback {
+		v.scrollback = nil
+		v.linesScrolled = 0
+	}
+}
+
+// selectiveEraseRegion is like eraseRegion, but skips protected cells.
+func (v *VT100) selectiveEraseRegion(y1, x1, y2, x2 int) {
+	y1, x1, y2, x2 = v.clip(y1, x1, y2, x2)
+	for y := y1; y <= y2; y++ {
+		for x := x1; x <= x2; x++ {
+			if !v.Format[y][x].Protected {
+				v.clear(y, x)
+			}
+		}
 	}
 }
 
 func (v *VT100) eraseRegion(y1, x1, y2, x2 int) {
-	// Do not sanitize or bounds-check these coordinates, since they come from the
-	// programmer (me). We should panic if any of them are out of bounds.
+	y1, x1, y2, x2 = v.clip(y1, x1, y2, x2)
+	for y := y1; y <= y2; y++ {
+		for x := x1; x <= x2; x++ {
+			v.clear(y, x)
+		}
+		if x2 == v.Width-1 && y+1 < v.Height {
+			// The row no longer runs all the way to the edge.
+			v.LineWrapped[y+1] = false
+		}
+	}
+}
+
+// clip orders the corners of a region and clamps them to the screen. The
+// cursor can be just past the bottom, after writing to the last cell, so
+// regions that start from it can be too.
+func (v *VT100) clip(y1, x1, y2, x2 int) (int, int, int, int) {
 	if y1 > y2 {
 		y1, y2 = y2, y1
 	}
 	if x1 > x2 {
 		x1, x2 = x2, x1
 	}
-
-	for y := y1; y <= y2; y++ {
-		for x := x1; x <= x2; x++ {
-			v.clear(y, x)
-		}
+	if y1 < 0 {
+		y1 = 0
+	}
+	if x1 < 0 {
+		x1 = 0
+	}
+	if y2 >= v.Height {
+		y2 = v.Height - 1
+	}
+	if x2 >= v.Width {
+		x2 = v.Width - 1
 	}
+	return y1, x1, y2, x2
 }
 
 func (v *VT100) clear(y, x int) {
@@ -469,25 +2527,97 @@ func (v *VT100) clear(y, x int) {
 		return
 	}
 	v.Content[y][x] = ' '
-	v.Format[y][x] = Format{}
+	v.Format[y][x] = v.DefaultFormat
+	v.damage.touch(y, y)
 }
 
 func (v *VT100) backspace() {
+	if v.regionWrap {
+		// Like going back from a row that the cursor has just wrapped
+		// onto, this undoes the wrap.
+		v.regionWrap = false
+		return
+	}
 	v.Cursor.X--
 	if v.Cursor.X < 0 {
-		if v.Cursor.Y == 0 {
+		// The cursor wraps as soon as the last column is written, so going
+		// back from there undoes that, whether or not ReverseWrap is set.
+		justWrapped := v.wrappedTo > 0 && v.Cursor.Y == v.wrappedTo
+		if v.Cursor.Y == 0 || !(v.ReverseWrap || justWrapped) {
 			v.Cursor.X = 0
 		} else {
 			v.Cursor.Y--
 			v.Cursor.X = v.Width - 1
 		}
+		v.wrappedTo = 0
+	}
+}
+
+// SavedState is the state saved by DECSC or PushCursor: the cursor itself,
+// along with the character sets and origin mode.
+type SavedState struct {
+	Cursor     Cursor
+	G0, G1     Charset
+	ShiftOut   bool
+	OriginMode bool
+}
+
+// PushCursor saves the state of the cursor onto a stack, from which PopCursor
+// restores it. DECSC and DECRC save to and restore from the top of the same
+// stack, without growing or shrinking it.
+func (v *VT100) PushCursor() {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	v.savedCursors = append(v.savedCursors, v.savedState())
+}
+
+// PopCursor restores the cursor state most recently saved by PushCursor and
+// removes it from the stack. It returns false, and changes nothing, if the
+// stack is empty.
+func (v *VT100) PopCursor() bool {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+	n := len(v.savedCursors)
+	if n == 0 {
+		return false
+	}
+	v.restoreState(v.savedCursors[n-1])
+	v.savedCursors = v.savedCursors[:n-1]
+	v.notify()
+	return true
+}
+
+func (v *VT100) savedState() SavedState {
+	return SavedState{
+		Cursor:     v.Cursor,
+		G0:         v.G0,
+		G1:         v.G1,
+		ShiftOut:   v.ShiftOut,
+		OriginMode: v.OriginMode,
 	}
 }
 
+func (v *VT100) restoreState(s SavedState) {
+	v.Cursor = s.Cursor
+	// The terminal may have shrunk since the state was saved.
+	v.home(v.Cursor.Y, v.Cursor.X)
+	v.G0, v.G1 = s.G0, s.G1
+	v.ShiftOut = s.ShiftOut
+	v.OriginMode = s.OriginMode
+}
+
 func (v *VT100) save() {
-	v.savedCursor = v.Cursor
+	if n := len(v.savedCursors); n > 0 {
+		v.savedCursors[n-1] = v.savedState()
+	} else {
+		v.savedCursors = append(v.savedCursors, v.savedState())
+	}
 }
 
 func (v *VT100) unsave() {
-	v.Cursor = v.savedCursor
+	var s SavedState
+	if n := len(v.savedCursors); n > 0 {
+		s = v.savedCursors[n-1]
+	}
+	v.restoreState(s)
 }
@@ -0,0 +1,1195 @@
+package vt100_test
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"unicode"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+	"github.com/vito/vt100/vttest"
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestHTMLTextProperties(t *testing.T) {
+	const colors = "background-color:#000000;color:#aaaaaa"
+	for _, tc := range []struct {
+		f    Format
+		want string
+	}{
+		{Format{Italic: true}, colors + ";font-style:italic"},
+		{Format{CrossOut: true}, colors + ";text-decoration:line-through"},
+		{Format{Overline: true}, colors + ";text-decoration:overline"},
+		{
+			Format{Underline: true, Overline: true, CrossOut: true},
+			colors + ";text-decoration:underline overline line-through",
+		},
+		{Format{Blink: true}, colors + ";text-decoration:blink"},
+		{Format{RapidBlink: true}, "animation:blink 0.2s step-end infinite;" + colors},
+		{
+			Format{Underline: true, UnderlineStyle: CurlyUnderline},
+			colors + ";text-decoration-style:wavy;text-decoration:underline",
+		},
+		{
+			Format{Underline: true, UnderlineColor: termenv.RGBColor("#ff8000")},
+			colors + ";text-decoration-color:#ff8000;text-decoration:underline",
+		},
+		{
+			Format{Underline: true, UnderlineStyle: DoubleUnderline},
+			colors + ";text-decoration-style:double;text-decoration:underline",
+		},
+	} {
+		v := vttest.FromLinesAndFormats("a", [][]Format{{tc.f}})
+		assert.Contains(t, v.HTML(), `<span style="`+tc.want+`">`, "while rendering %+v", tc.f)
+	}
+}
+
+func TestHTMLBlinkClasses(t *testing.T) {
+	const colors = "background-color:#000000;color:#aaaaaa"
+	v := NewVT100(1, 3)
+	v.Write([]byte(esc("[5m") + "a" + esc("[6m") + "b" + esc("[25m") + "c"))
+	assert.True(t, v.Format[0][0].Blink)
+	assert.True(t, v.Format[0][1].RapidBlink)
+
+	html := v.HTMLWithOptions(HTMLOptions{BlinkClasses: true})
+	assert.Contains(t, html, `<span class="blink" style="`+colors+`">a</span>`)
+	assert.Contains(t, html, `<span class="rapid-blink" style="`+colors+`">b</span>`)
+	assert.Equal(t, v.HTML(), v.HTMLWithOptions(HTMLOptions{}))
+	assert.Contains(t, v.HTML(), `<span style="`+colors+`;text-decoration:blink">a</span>`)
+}
+
+func TestFormatString(t *testing.T) {
+	assert.Equal(t, "", Format{}.String())
+	assert.Equal(t, "bold,fg=red", Format{Fg: termenv.ANSIRed, Intensity: Bold}.String())
+	assert.Equal(t, "reset", Format{Reset: true}.String())
+	assert.Equal(t, "reset,italic", Format{Reset: true, Italic: true}.String())
+}
+
+func TestRegion(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	v := vttest.FromLinesAndFormats("abcd\nefgh\nijkl", [][]Format{
+		{{}, {}, {}, {}},
+		{{}, red, red, {}},
+		{{}, {}, {}, {}},
+	})
+	v.Cursor = Cursor{Y: 2, X: 3}
+
+	r, err := v.Region(1, 1, 2, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, r.Height)
+	assert.Equal(t, 2, r.Width)
+	assert.Equal(t, splitLines("fg\njk"), r.Content)
+	assert.Equal(t, [][]Format{{red, red}, {{}, {}}}, r.Format)
+	assert.Equal(t, Cursor{}, r.Cursor)
+
+	// The region is a copy.
+	r.Content[0][0] = 'z'
+	assert.Equal(t, 'f', v.Content[1][1])
+
+	// Coordinates past the edges are clamped.
+	r, err = v.Region(-1, 2, 10, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, splitLines("cd\ngh\nkl"), r.Content)
+
+	_, err = v.Region(2, 0, 1, 0)
+	assert.Error(t, err)
+
+	_, err = v.Region(3, 0, 5, 2)
+	assert.Error(t, err)
+}
+
+func TestLine(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	v := vttest.FromLinesAndFormats("ab  \n    ", [][]Format{
+		{red, {}, {}, {}},
+		{{}, {}, red, {}},
+	})
+
+	s, fs, err := v.Line(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "ab  ", s)
+	assert.Equal(t, []Format{red, {}, {}, {}}, fs)
+
+	s, fs, err = v.TrimmedLine(0)
+	assert.Nil(t, err)
+	assert.Equal(t, "ab", s)
+	assert.Equal(t, []Format{red, {}}, fs)
+
+	// Formatted blanks are kept.
+	s, fs, err = v.TrimmedLine(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "   ", s)
+	assert.Equal(t, []Format{{}, {}, red}, fs)
+
+	// The formats are a copy.
+	fs[2] = Format{}
+	assert.Equal(t, red, v.Format[1][2])
+
+	assert.Equal(t, []string{"ab", "   "}, v.TrimmedLines())
+
+	_, _, err = v.Line(2)
+	assert.Error(t, err)
+	_, _, err = v.TrimmedLine(-1)
+	assert.Error(t, err)
+}
+
+func TestRow(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	v := vttest.FromLinesAndFormats("ab\ncd", [][]Format{
+		{red, {}},
+		{{}, red},
+	})
+
+	runes, formats, ok := v.Row(1)
+	assert.True(t, ok)
+	assert.Equal(t, v.Content[1], runes)
+	assert.Equal(t, []Format{{}, red}, formats)
+
+	// They're copies.
+	runes[0] = 'x'
+	formats[1] = Format{}
+	assert.Equal(t, 'c', v.Content[1][0])
+	assert.Equal(t, red, v.Format[1][1])
+
+	_, _, ok = v.Row(-1)
+	assert.False(t, ok)
+	_, _, ok = v.Row(2)
+	assert.False(t, ok)
+}
+
+func TestEachLine(t *testing.T) {
+	v := NewVT100(4, 3)
+	v.Write([]byte("ab\r\n" + esc("[1m") + "c\r\nd"))
+
+	var ys []int
+	var lines []string
+	v.EachLine(func(y int, content []rune, format []Format) bool {
+		ys = append(ys, y)
+		lines = append(lines, string(content))
+		if y == 1 {
+			assert.Equal(t, Bold, format[0].Intensity)
+		}
+		return true
+	})
+	assert.Equal(t, []int{0, 1, 2}, ys)
+	assert.Equal(t, []string{"ab ", "c  ", "d  "}, lines)
+
+	ys = nil
+	v.EachLine(func(y int, _ []rune, _ []Format) bool {
+		ys = append(ys, y)
+		return y < 1
+	})
+	assert.Equal(t, []int{0, 1}, ys)
+}
+
+func TestUnderlineStyleVariants(t *testing.T) {
+	const colors = "background-color:#000000;color:#aaaaaa"
+	for _, tc := range []struct {
+		seq   string
+		style UnderlineStyle
+		css   string
+	}{
+		{"[4:1m", SingleUnderline, colors + ";text-decoration:underline"},
+		{"[4:2m", DoubleUnderline, colors + ";text-decoration-style:double;text-decoration:underline"},
+		{"[4:3m", CurlyUnderline, colors + ";text-decoration-style:wavy;text-decoration:underline"},
+		{"[4:4m", DottedUnderline, colors + ";text-decoration-style:dotted;text-decoration:underline"},
+		{"[4:5m", DashedUnderline, colors + ";text-decoration-style:dashed;text-decoration:underline"},
+	} {
+		v := NewVT100(1, 1)
+		v.Write([]byte("\u001b" + tc.seq + "a"))
+		assert.Equal(t, tc.style, v.Format[0][0].UnderlineStyle, "while decoding %q", tc.seq)
+		assert.Contains(t, v.HTML(), `<span style="`+tc.css+`">`, "while decoding %q", tc.seq)
+	}
+
+	// The style alone is enough to underline.
+	v := vttest.FromLinesAndFormats("a", [][]Format{{{UnderlineStyle: DottedUnderline}}})
+	assert.Contains(t, v.HTML(), "text-decoration-style:dotted;text-decoration:underline")
+}
+
+func TestShift(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	v := vttest.FromLinesAndFormats("abc\ndef\nghi", [][]Format{
+		{red, {}, {}},
+		{{}, red, {}},
+		{{}, {}, red},
+	})
+	v.Cursor = Cursor{Y: 1, X: 2}
+
+	v.Shift(1, 1)
+	assert.Equal(t, splitLines("   \n ab\n de"), v.Content)
+	assert.Equal(t, [][]Format{
+		{{}, {}, {}},
+		{{}, red, {}},
+		{{}, {}, red},
+	}, v.Format)
+	assert.Equal(t, Cursor{Y: 1, X: 2}, v.Cursor)
+
+	v.Shift(-1, -2)
+	assert.Equal(t, splitLines("b  \ne  \n   "), v.Content)
+
+	// Large offsets clear everything.
+	v.Shift(100, -100)
+	assert.Equal(t, splitLines("   \n   \n   "), v.Content)
+
+	// Even the largest ones.
+	for _, d := range [][2]int{{0, math.MinInt}, {math.MinInt, 0}, {math.MaxInt, math.MaxInt}, {math.MinInt, math.MinInt}} {
+		v = vttest.FromLines("abc\ndef")
+		assert.NotPanics(t, func() { v.Shift(d[0], d[1]) }, "shifting by %v", d)
+		assert.Equal(t, splitLines("   \n   "), v.Content, "shifting by %v", d)
+	}
+}
+
+func TestResizeKeepingTail(t *testing.T) {
+	v, err := New(WithSize(5, 2), WithScrollback(10))
+	assert.Nil(t, err)
+	v.Write([]byte("1\r\n2\r\n3\r\n4\r\n5"))
+
+	v.ResizeKeepingTail(3, 3)
+	assert.Equal(t, splitLines("3  \n4  \n5  "), v.Content)
+	assert.Equal(t, 3, v.Height)
+	assert.Equal(t, 2, v.Cursor.Y)
+	assert.Equal(t, 1, v.Cursor.X)
+	assert.Equal(t, 3, v.UsedHeight())
+	assert.Equal(t, 2, v.ScrollbackLen())
+	line, _, _ := v.ScrollbackLine(0)
+	assert.Equal(t, "1 ", line)
+
+	// Rows below the cursor go first.
+	v.Write([]byte(esc("[1;1H")))
+	v.ResizeKeepingTail(1, 3)
+	assert.Equal(t, splitLines("3  "), v.Content)
+	assert.Equal(t, 2, v.ScrollbackLen())
+
+	// Growing adds rows at the bottom, as usual.
+	v.ResizeKeepingTail(2, 3)
+	assert.Equal(t, splitLines("3  \n   "), v.Content)
+
+	// A full screen keeps its last lines without a scrollback too, even with
+	// the cursor waiting to scroll past the bottom.
+	v = NewVT100(4, 2)
+	v.Write([]byte("1\r\n2\r\n3\r\n4\r\n"))
+	v.ResizeKeepingTail(2, 2)
+	assert.Equal(t, splitLines("3 \n4 "), v.Content)
+	assert.Equal(t, Cursor{Y: 2}, v.Cursor)
+	assert.Equal(t, 2, v.UsedHeight())
+	assert.Equal(t, 0, v.ScrollbackLen())
+}
+
+func TestResizeAnchored(t *testing.T) {
+	v := NewVT100(3, 6)
+	v.Write([]byte("hello world"))
+	assert.Equal(t, Cursor{Y: 1, X: 5}, v.Cursor)
+
+	// Narrowing re-wraps the text, and shortening drops the top rows, so the
+	// cursor stays just after "world".
+	v.ResizeAnchored(2, 4)
+	assert.Equal(t, splitLines("o wo\nrld "), v.Content)
+	assert.Equal(t, Cursor{Y: 1, X: 3}, v.Cursor)
+	v.Write([]byte("!"))
+	assert.Equal(t, splitLines("o wo\nrld!"), v.Content)
+
+	// The cursor follows the cell it's on when widening, too.
+	v = NewVT100(3, 4)
+	v.Write([]byte("abcdefgh" + esc("[2;2H")))
+	v.ResizeAnchored(3, 8)
+	assert.Equal(t, splitLines("abcdefgh\n        \n        "), v.Content)
+	assert.Equal(t, Cursor{Y: 0, X: 5}, v.Cursor)
+
+	// Whereas Resize just clamps the cursor.
+	v = NewVT100(3, 6)
+	v.Write([]byte("hello world"))
+	v.Resize(2, 4)
+	assert.Equal(t, splitLines("hell\nworl"), v.Content)
+	assert.Equal(t, Cursor{Y: 1, X: 3}, v.Cursor)
+}
+
+func TestLogicalLines(t *testing.T) {
+	v := NewVT100(4, 5)
+	v.Write([]byte("abcdefghij"))
+	assert.False(t, v.LineWrapped[0])
+	assert.True(t, v.LineWrapped[1])
+	assert.Equal(t, []LogicalLine{
+		{Runes: []rune("abcdefghij"), Formats: make([]Format, 10), WrappedAt: []int{5}},
+		{},
+	}, v.LogicalLines())
+
+	// The cursor wraps as soon as the last column is written, but ending the
+	// line right away means it didn't wrap after all.
+	assert.True(t, v.LineWrapped[2])
+	v.Write([]byte("\r\nxy"))
+	assert.False(t, v.LineWrapped[2])
+	assert.Equal(t, []LogicalLine{
+		{Runes: []rune("abcdefghij"), Formats: make([]Format, 10), WrappedAt: []int{5}},
+		{},
+		{Runes: []rune("xy"), Formats: make([]Format, 2)},
+	}, v.LogicalLines())
+
+	// Whereas anything written afterwards is still part of the line.
+	v = NewVT100(2, 5)
+	v.Write([]byte("abcdefg\r\n"))
+	assert.True(t, v.LineWrapped[1])
+	assert.Equal(t, []rune("abcdefg"), v.LogicalLines()[0].Runes)
+
+	v.Write([]byte(esc("[H") + "abcde" + esc("[H")))
+	assert.False(t, v.LineWrapped[1])
+}
+
+func TestLineWrapped(t *testing.T) {
+	// Wrapping past the bottom is marked once the terminal scrolls.
+	v := NewVT100(2, 3)
+	v.Write([]byte("abcdef"))
+	assert.Equal(t, []bool{false, true}, v.LineWrapped)
+	v.Write([]byte("g"))
+	assert.Equal(t, splitLines("def\ng  "), v.Content)
+	assert.Equal(t, []bool{false, true}, v.LineWrapped)
+
+	// Or grows.
+	v = NewVT100(1, 3)
+	v.AutoResizeY = true
+	v.Write([]byte("abcdefg"))
+	assert.Equal(t, 3, v.Height)
+	assert.Equal(t, []bool{false, true, true}, v.LineWrapped)
+
+	// Reflowing moves the marks along with the rows. The cursor, just past
+	// the end of the line, has wrapped onto the row after it.
+	v = NewVT100(3, 4)
+	v.Reflow = true
+	v.Write([]byte("abcdef"))
+	assert.Equal(t, []bool{false, true, false}, v.LineWrapped)
+	v.Resize(3, 3)
+	assert.Equal(t, splitLines("abc\ndef\n   "), v.Content)
+	assert.Equal(t, []bool{false, true, true}, v.LineWrapped)
+	v.Write([]byte("\r\n"))
+	v.Resize(3, 6)
+	assert.Equal(t, splitLines("abcdef\n      \n      "), v.Content)
+	assert.Equal(t, []bool{false, false, false}, v.LineWrapped)
+}
+
+func TestOnScroll(t *testing.T) {
+	v := NewVT100(2, 3)
+	var scrolled []string
+	var formats [][]Format
+	v.OnScroll = func(content []rune, format []Format) {
+		scrolled = append(scrolled, string(content))
+		formats = append(formats, append([]Format(nil), format...))
+	}
+
+	v.Write([]byte("a\r\n" + esc("[1m") + "b\r\nc\r\nd\r\ne"))
+	assert.Equal(t, []string{"a  ", "b  ", "c  "}, scrolled)
+	assert.Equal(t, Format{Intensity: Bold}, formats[1][0])
+	assert.Equal(t, 3, v.LinesScrolled())
+
+	v.Write([]byte(esc("[2S")))
+	assert.Equal(t, []string{"a  ", "b  ", "c  ", "d  ", "e  "}, scrolled)
+	assert.Equal(t, 5, v.LinesScrolled())
+
+	// Rows dropped from the top by resizing count too.
+	scrolled = nil
+	v = NewVT100(3, 3)
+	v.OnScroll = func(content []rune, format []Format) {
+		scrolled = append(scrolled, string(content))
+	}
+	v.Write([]byte("a\r\nb\r\nc"))
+	v.ResizeKeepingTail(2, 3)
+	assert.Equal(t, []string{"a  "}, scrolled)
+	assert.Equal(t, 1, v.LinesScrolled())
+
+	// And so do those pushed off by reflowing.
+	v.Reflow = true
+	v.Write([]byte("def"))
+	v.Resize(2, 2)
+	assert.Equal(t, []string{"a  ", "b  ", "cd"}, scrolled)
+	assert.Equal(t, 3, v.LinesScrolled())
+}
+
+func TestReset(t *testing.T) {
+	v, err := New(WithSize(2, 3), WithScrollback(10))
+	assert.Nil(t, err)
+	v.Write([]byte("a\r\nb\r\nc" + esc("[1;31m") + esc("[4h") + esc("[?6h") + esc("(0")))
+	assert.Equal(t, 1, v.LinesScrolled())
+
+	v.Reset()
+	assert.Equal(t, splitLines("   \n   "), v.Content)
+	assert.Equal(t, 0, v.ScrollbackLen())
+	assert.Equal(t, 0, v.LinesScrolled())
+	assert.Equal(t, 0, v.UsedHeight())
+	assert.Equal(t, Cursor{}, v.Cursor)
+	assert.False(t, v.InsertMode)
+	assert.False(t, v.OriginMode)
+	assert.Equal(t, CharsetASCII, v.G0)
+
+	v.Write([]byte("d\r\ne\r\nf"))
+	assert.Equal(t, 1, v.LinesScrolled())
+}
+
+func TestUsedHeight(t *testing.T) {
+	v := NewVT100(3, 2)
+	assert.Equal(t, 0, v.UsedHeight())
+
+	v.Write([]byte("a\r\nb"))
+	assert.Equal(t, 2, v.UsedHeight())
+
+	// Scrolling by writing keeps the bottom row in use.
+	v.Write([]byte("\r\nc\r\nd\r\ne"))
+	assert.Equal(t, splitLines("c \nd \ne "), v.Content)
+	assert.Equal(t, 3, v.UsedHeight())
+
+	// A trailing newline doesn't use the row it moves to.
+	v.Write([]byte("\r\n"))
+	assert.Equal(t, 3, v.UsedHeight())
+	v.Write([]byte("f"))
+	assert.Equal(t, splitLines("d \ne \nf "), v.Content)
+	assert.Equal(t, 3, v.UsedHeight())
+
+	// Scrolling without writing moves the used rows up, and then off the
+	// top.
+	v.Write([]byte(esc("[2S")))
+	assert.Equal(t, splitLines("f \n  \n  "), v.Content)
+	assert.Equal(t, 1, v.UsedHeight())
+	v.Write([]byte(esc("[T")))
+	assert.Equal(t, 2, v.UsedHeight())
+	v.Write([]byte(esc("[3S")))
+	assert.Equal(t, 0, v.UsedHeight())
+
+	// Shrinking to the used height keeps it in bounds.
+	v = NewVT100(3, 2)
+	v.Write([]byte("a\r\nb\r\nc"))
+	v.Resize(2, 2)
+	assert.Equal(t, 2, v.UsedHeight())
+}
+
+func TestResetUsed(t *testing.T) {
+	v := NewVT100(24, 10)
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(v, "line %d\r\n", i)
+	}
+	assert.Equal(t, 20, v.UsedHeight())
+
+	v.Write([]byte(esc("[H") + esc("[2J") + "a\r\nb\r\n"))
+	assert.Equal(t, 2, v.UsedHeight())
+
+	v.ResetUsed()
+	assert.Equal(t, 0, v.UsedHeight())
+	assert.Equal(t, "a", string(v.Content[0][0]))
+	v.Write([]byte("c"))
+	assert.Equal(t, 3, v.UsedHeight())
+}
+
+func TestReflow(t *testing.T) {
+	v := NewVT100(3, 6)
+	v.Reflow = true
+	v.Write([]byte("abcdef"))
+
+	v.Resize(3, 3)
+	assert.Equal(t, splitLines("abc\ndef\n   "), v.Content)
+	assert.Equal(t, 2, v.Cursor.Y)
+	assert.Equal(t, 0, v.Cursor.X)
+
+	v.Resize(3, 6)
+	assert.Equal(t, splitLines("abcdef\n      \n      "), v.Content)
+	assert.Equal(t, 1, v.Cursor.Y)
+	assert.Equal(t, 0, v.Cursor.X)
+
+	// Lines that weren't wrapped stay apart.
+	v = NewVT100(2, 4)
+	v.Reflow = true
+	v.Write([]byte("ab\r\nc"))
+	v.Resize(2, 2)
+	assert.Equal(t, splitLines("ab\nc "), v.Content)
+	assert.Equal(t, 1, v.Cursor.Y)
+	assert.Equal(t, 1, v.Cursor.X)
+}
+
+func TestSetFormatAndPutRune(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	v := NewVT100(1, 4)
+	v.Write([]byte(esc("[1m")))
+	assert.Equal(t, Bold, v.CurrentFormat().Intensity)
+
+	v.SetFormat(red)
+	assert.Equal(t, red, v.CurrentFormat())
+	v.PutRune('a')
+	v.PutRune('\t')
+	v.Write([]byte("b"))
+	assert.Equal(t, "a\tb ", string(v.Content[0]))
+	assert.Equal(t, []Format{red, red, red, {}}, v.Format[0])
+	assert.Equal(t, 3, v.Cursor.X)
+}
+
+func TestWriteAt(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	bold := Format{Intensity: Bold}
+	v := NewVT100(3, 5)
+	v.SetFormat(bold)
+
+	assert.Nil(t, v.WriteAt(1, 1, "héy", red))
+	assert.Equal(t, splitLines("     \n héy \n     "), v.Content)
+	assert.Equal(t, []Format{{}, red, red, red, {}}, v.Format[1])
+	assert.Equal(t, Cursor{Y: 1, X: 4, F: bold}, v.Cursor)
+
+	// Coordinates out of bounds are clamped.
+	assert.NotNil(t, v.WriteAt(10, -3, "ab", red))
+	assert.Equal(t, splitLines("     \n héy \nab   "), v.Content)
+	assert.Equal(t, Cursor{Y: 2, X: 2, F: bold}, v.Cursor)
+}
+
+func TestPutLiteral(t *testing.T) {
+	bold := Format{Intensity: Bold}
+	v := NewVT100(2, 3)
+	v.SetFormat(bold)
+
+	v.PutLiteral("a\x1b[2Jb")
+	assert.Equal(t, splitLines("a\x1b[\n2Jb"), v.Content)
+	assert.Equal(t, []Format{bold, bold, bold}, v.Format[1])
+	assert.Equal(t, Cursor{Y: 2, X: 0, F: bold}, v.Cursor)
+	assert.Contains(t, v.HTML(), "a\u241b[")
+
+	// Wrapping past the bottom scrolls, as usual.
+	v.PutLiteral("\n")
+	assert.Equal(t, [][]rune{[]rune("2Jb"), []rune("\n  ")}, v.Content)
+}
+
+func TestCell(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	v := vttest.FromLines("ab\ncd")
+
+	c, ok := v.CellAt(1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, Cell{Rune: 'c'}, c)
+
+	v.SetCell(0, 1, Cell{Rune: 'x', Format: red})
+	c, ok = v.CellAt(0, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Cell{Rune: 'x', Format: red}, c)
+	assert.Equal(t, splitLines("ax\ncd"), v.Content)
+
+	for _, yx := range [][2]int{{-1, 0}, {0, -1}, {2, 0}, {0, 2}} {
+		_, ok := v.CellAt(yx[0], yx[1])
+		assert.False(t, ok, "at %v", yx)
+		v.SetCell(yx[0], yx[1], Cell{Rune: 'z'})
+	}
+	assert.Equal(t, splitLines("ax\ncd"), v.Content)
+}
+
+func TestFormatAt(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	v := vttest.FromLinesAndFormats("ab\ncd", [][]Format{
+		{{}, red},
+		{{}, {}},
+	})
+
+	f, ok := v.FormatAt(0, 1)
+	assert.True(t, ok)
+	assert.Equal(t, red, f)
+	f, ok = v.FormatAt(1, 1)
+	assert.True(t, ok)
+	assert.Equal(t, Format{}, f)
+
+	for _, yx := range [][2]int{{-1, 0}, {0, -1}, {2, 0}, {0, 2}} {
+		_, ok := v.FormatAt(yx[0], yx[1])
+		assert.False(t, ok, "at %v", yx)
+	}
+}
+
+func TestSetFormatRegion(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	v := vttest.FromLines("abc\ndef\nghi")
+
+	v.SetFormatAt(1, 1, red)
+	assert.Equal(t, red, v.Format[1][1])
+	assert.Equal(t, 'e', v.Content[1][1])
+	assert.Equal(t, Format{}, v.Format[1][0])
+
+	bold := Format{Intensity: Bold}
+	v.SetFormatRegion(1, 2, 0, 1, bold)
+	assert.Equal(t, [][]Format{
+		{{}, bold, bold},
+		{{}, bold, bold},
+		{{}, {}, {}},
+	}, v.Format)
+	assert.Equal(t, splitLines("abc\ndef\nghi"), v.Content)
+
+	// Coordinates out of bounds are clamped.
+	v.SetFormatRegion(-5, -5, 100, 0, red)
+	v.SetFormatAt(100, 100, red)
+	assert.Equal(t, [][]Format{
+		{red, bold, bold},
+		{red, bold, bold},
+		{red, {}, red},
+	}, v.Format)
+}
+
+func TestHighlightRegion(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed, Bg: termenv.ANSIWhite}
+	v := vttest.FromLinesAndFormats("abc\ndef", [][]Format{
+		{red, red, {}},
+		{{}, {}, {Italic: true}},
+	})
+
+	v.HighlightRegion(0, 1, 1, 2, Format{Intensity: Bold})
+	assert.Equal(t, [][]Format{
+		{red, {Fg: termenv.ANSIRed, Bg: termenv.ANSIWhite, Intensity: Bold}, {Intensity: Bold}},
+		{{}, {Intensity: Bold}, {Intensity: Bold, Italic: true}},
+	}, v.Format)
+	assert.Equal(t, splitLines("abc\ndef"), v.Content)
+
+	// Set fields override the cell's, and a nil Bg leaves the cell's alone.
+	v.HighlightRegion(0, 0, 0, 0, Format{Fg: termenv.ANSIYellow})
+	assert.Equal(t, Format{Fg: termenv.ANSIYellow, Bg: termenv.ANSIWhite}, v.Format[0][0])
+}
+
+func TestUnderlineColorHTML(t *testing.T) {
+	v := NewVT100(1, 1)
+	v.Write([]byte("\u001b[4;58;2;18;52;86ma"))
+	assert.Equal(t, termenv.RGBColor("#123456"), v.Format[0][0].UnderlineColor)
+	assert.Contains(t, v.HTML(), "text-decoration-color:#123456;text-decoration:underline")
+}
+
+func TestHTMLReverseDefaultColors(t *testing.T) {
+	v := vttest.FromLinesAndFormats("ab", [][]Format{{
+		{Reverse: true},
+		{Reverse: true, Fg: termenv.ANSIRed},
+	}})
+	html := v.HTML()
+	assert.Contains(t, html, `<span style="background-color:#aaaaaa;color:#000000">a`)
+	assert.Contains(t, html, `<span style="background-color:#800000;color:#000000">b`)
+}
+
+func TestHTMLDefaultColors(t *testing.T) {
+	white := termenv.RGBColor("#ffffff")
+	v, err := New(WithSize(1, 3), WithDefaultColors(termenv.RGBColor("#333333"), white))
+	assert.Nil(t, err)
+	v.Write([]byte("a" + esc("[31m") + "b" + esc("[7m") + "c"))
+
+	html := v.HTML()
+	assert.Contains(t, html, `<pre style="color:#333333;background-color:#ffffff;">a<span`)
+	assert.Contains(t, html, `<span style="background-color:#ffffff;color:#800000">b</span>`)
+	assert.Contains(t, html, `<span style="background-color:#800000;color:#ffffff">c`)
+}
+
+func TestDefaultFormat(t *testing.T) {
+	blue := Format{Bg: termenv.ANSIBlue}
+	v, err := New(WithSize(2, 3), WithDefaultFormat(blue))
+	assert.Nil(t, err)
+	for _, row := range v.Format {
+		assert.Equal(t, []Format{blue, blue, blue}, row)
+	}
+
+	// Erased and scrolled in cells are blank with it too, and ignored by
+	// TrimmedLines.
+	v.Write([]byte("ab\r\ncd" + esc("[H") + esc("[K") + esc("[S")))
+	assert.Equal(t, splitLines("cd \n   "), v.Content)
+	assert.Equal(t, []Format{{}, {}, blue}, v.Format[0])
+	assert.Equal(t, []Format{blue, blue, blue}, v.Format[1])
+	assert.Equal(t, []string{"cd", ""}, v.TrimmedLines())
+	assert.Contains(t, v.HTML(), `<span style="background-color:#000080;color:#aaaaaa"> `+"\n"+`   `)
+}
+
+func TestHTMLConceal(t *testing.T) {
+	v := vttest.FromLinesAndFormats("abc", [][]Format{{{}, {Conceal: true}, {}}})
+
+	// The concealed character is hidden but still laid out.
+	assert.Equal(t,
+		`<pre style="color:white;background-color:black;">`+
+			`a<span style="background-color:#000000;color:#aaaaaa;visibility:hidden">b</span>c`+"\n"+
+			`</pre>`,
+		v.HTML())
+}
+
+func TestDebugDump(t *testing.T) {
+	v := NewVT100(2, 8)
+	v.Write([]byte(esc("[1;31m") + "abc" + esc("[0;44m") + "def" + esc("[m") + "g\r\n"))
+	v.Write([]byte(esc("[4:3;38;5;200;48;2;1;2;3m") + "x"))
+
+	assert.Equal(t,
+		"{bold,fg=red}abc{/}{bg=blue}def{/}g \n"+
+			"{bg=#010203,fg=200,underline=curly}x{/}       \n",
+		v.DebugDump())
+}
+
+func TestWriteWithDamage(t *testing.T) {
+	v := NewVT100(3, 4)
+
+	_, d, err := v.WriteWithDamage([]byte("ab\r\ncd"))
+	assert.Nil(t, err)
+	assert.Equal(t, Damage{Top: 0, Bottom: 1}, d)
+
+	// Moving the cursor doesn't damage anything.
+	_, d, err = v.WriteWithDamage([]byte("\u001b[H"))
+	assert.Nil(t, err)
+	assert.Equal(t, Damage{Top: -1, Bottom: -1}, d)
+
+	_, d, err = v.WriteWithDamage([]byte("\u001b[3;1Hx\r\ny\r\nz"))
+	assert.Nil(t, err)
+	assert.Equal(t, Damage{Top: 0, Bottom: 2, Scrolled: 2}, d)
+	assert.Equal(t, splitLines("x   \ny   \nz   "), v.Content)
+
+	_, d, err = v.WriteWithDamage([]byte("\u001b[2J"))
+	assert.Nil(t, err)
+	assert.Equal(t, Damage{Top: 0, Bottom: 2}, d)
+
+	v.AutoResizeY = true
+	_, d, err = v.WriteWithDamage([]byte("\r\n\r\nw"))
+	assert.Nil(t, err)
+	assert.Equal(t, Damage{Top: 3, Bottom: 4, Resized: true}, d)
+}
+
+func TestWriteCounting(t *testing.T) {
+	v := NewVT100(3, 4)
+
+	n, cells, err := v.WriteCounting([]byte("abc"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, cells)
+
+	// Overwriting cells with what they already hold changes nothing.
+	_, cells, err = v.WriteCounting([]byte("\rab"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, cells)
+
+	// A change of format alone counts.
+	_, cells, _ = v.WriteCounting([]byte("\r\x1b[1mab"))
+	assert.Equal(t, 2, cells)
+
+	// But not when the format is the same as before.
+	_, cells, _ = v.WriteCounting([]byte("\rab"))
+	assert.Equal(t, 0, cells)
+
+	// Erasing counts only the cells that weren't already blank.
+	_, cells, _ = v.WriteCounting([]byte("\x1b[2J"))
+	assert.Equal(t, 3, cells)
+
+	// Scrolling counts every cell that ends up different.
+	v.Write([]byte("\x1b[Hab\r\ncd\r\nef"))
+	_, cells, _ = v.WriteCounting([]byte("\r\nx"))
+	assert.Equal(t, 6, cells)
+}
+
+func TestHTMLControlCharacters(t *testing.T) {
+	v := NewVT100(1, 5)
+	v.Write([]byte("a<b"))
+
+	// Nothing written to the terminal puts a control character in a cell,
+	// but SetCell can.
+	v.SetCell(0, 3, Cell{Rune: '\x01'})
+	v.SetCell(0, 4, Cell{Rune: '\x00'})
+
+	html := v.HTML()
+	assert.Contains(t, html, "a&lt;b\u2401\u2400\n")
+	for _, r := range html {
+		if r != '\n' {
+			assert.False(t, unicode.IsControl(r), "raw control character %q in %q", r, html)
+		}
+	}
+
+	v.SetCell(0, 0, Cell{Rune: 0x7f})
+	v.SetCell(0, 1, Cell{Rune: 0x85})
+	v.SetCell(0, 2, Cell{Rune: unicode.ReplacementChar})
+	assert.Contains(t, v.HTML(), "\u2421&#xfffd;&#xfffd;")
+
+	// As can runes that aren't valid at all.
+	v.SetCell(0, 0, Cell{Rune: -1})
+	v.SetCell(0, 1, Cell{Rune: 0xd800})
+	v.SetCell(0, 2, Cell{Rune: unicode.MaxRune + 1})
+	assert.Contains(t, v.HTML(), "&#xfffd;&#xfffd;&#xfffd;")
+}
+
+func TestWriteSplit(t *testing.T) {
+	in := "a\x1b[1;31mbü\x1b[0m\x1b]0;a long window title, over twelve bytes\a😀\r\n" +
+		"\x1b[2;3H\x1b(Bc\x1b]8;;http://example.com\x1b\\d\x1b[38:2::10:20:30me"
+
+	want := NewVT100(3, 10)
+	want.Write([]byte(in))
+	assert.Equal(t, []rune("abü😀      "), want.Content[0])
+	assert.Equal(t, []rune("  cde     "), want.Content[1])
+
+	for i := 0; i <= len(in); i++ {
+		v := NewVT100(3, 10)
+		v.Write([]byte(in[:i]))
+		v.Write([]byte(in[i:]))
+		assert.Equal(t, want.Snapshot(), v.Snapshot(), "while splitting at %d", i)
+	}
+}
+
+func TestWriteSplitRunes(t *testing.T) {
+	// One rune of each width that UTF-8 encodes.
+	for _, r := range []rune{'a', 'ü', '✓', '😀'} {
+		in := "<" + string(r) + ">"
+		for i := 0; i <= len(in); i++ {
+			v := NewVT100(1, 4)
+			v.Write([]byte(in[:i]))
+			v.Write([]byte(in[i:]))
+			assert.Equal(t, []rune{'<', r, '>', ' '}, v.Content[0], "while splitting %q at %d", in, i)
+			assert.Equal(t, 3, v.Cursor.X)
+		}
+	}
+
+	// And a rune split across three writes.
+	v := NewVT100(1, 3)
+	v.Write([]byte("\xf0"))
+	v.Write([]byte("\x9f\x98"))
+	assert.Equal(t, 0, v.Cursor.X)
+	v.Write([]byte("\x80"))
+	assert.Equal(t, '😀', v.Content[0][0])
+}
+
+func TestWriteSplitOSC(t *testing.T) {
+	v := NewVT100(1, 10)
+	var logs bytes.Buffer
+	v.DebugLogs = &logs
+
+	osc := esc("]8;;https://example.com/a/long/path/x") + esc("\\")
+	assert.Len(t, osc, 40)
+
+	v.Write([]byte("a" + osc[:5]))
+	v.Write([]byte(osc[5:] + "b"))
+	assert.Equal(t, "ab        ", string(v.Content[0]))
+
+	// The whole of it made it through to be handled.
+	assert.Contains(t, logs.String(), `"8;;https://example.com/a/long/path/x"`)
+}
+
+func TestMaxOSCLength(t *testing.T) {
+	v := NewVT100(1, 10)
+	var logs bytes.Buffer
+	v.DebugLogs = &logs
+	assert.Equal(t, DefaultMaxOSCLength, v.MaxOSCLength)
+
+	v.MaxOSCLength = 16
+	v.Write([]byte("a" + esc("]0;"+strings.Repeat("x", 1000))))
+	v.Write([]byte(strings.Repeat("x", 1000) + "\ab"))
+	assert.Equal(t, "ab        ", string(v.Content[0]))
+	assert.Contains(t, logs.String(), "longer than 16 bytes")
+}
+
+func TestInlineImages(t *testing.T) {
+	v := NewVT100(2, 10)
+	var logs bytes.Buffer
+	v.DebugLogs = &logs
+
+	image := "\u001b]1337;File=name=eC5wbmc=;inline=1:" + strings.Repeat("iVBORw0K", 200)
+	v.Write([]byte("ab" + image + "\acd" + image + esc("\\") + "ef"))
+	assert.Equal(t, "abcdef    ", string(v.Content[0]))
+	assert.Empty(t, logs.String())
+
+	// One too long to keep is discarded all the same, even when it arrives
+	// in pieces.
+	image = "\u001b]1337;File=inline=1:" + strings.Repeat("iVBORw0K", 100000) + "\a"
+	v.Write([]byte("\r\ngh"))
+	for i := 0; i < len(image); i += 1000 {
+		end := i + 1000
+		if end > len(image) {
+			end = len(image)
+		}
+		v.Write([]byte(image[i:end]))
+	}
+	v.Write([]byte("ij"))
+	assert.Equal(t, "abcdef    ", string(v.Content[0]))
+	assert.Equal(t, "ghij      ", string(v.Content[1]))
+	assert.Equal(t, Cursor{Y: 1, X: 4}, v.Cursor)
+}
+
+func TestDebugFunc(t *testing.T) {
+	v := NewVT100(2, 10)
+	var logs bytes.Buffer
+	v.DebugLogs = &logs
+	var events []DebugEvent
+	v.DebugFunc = func(event DebugEvent) {
+		events = append(events, event)
+	}
+
+	v.Write([]byte("ab" + esc("[5;6y") + "\r\nc" + esc("]0;title") + esc("[1m") + "d"))
+	assert.Empty(t, logs.String())
+	if !assert.Len(t, events, 2) {
+		return
+	}
+
+	assert.Equal(t, []byte(esc("[5;6y")), events[0].Raw)
+	assert.Equal(t, "command 'y'", events[0].Kind)
+	assert.Equal(t, 0, events[0].Y)
+	assert.Equal(t, 2, events[0].X)
+	var ue UnsupportedError
+	assert.True(t, errors.As(events[0].Err, &ue))
+	assert.Equal(t, []byte(esc("[5;6y")), ue.Raw)
+
+	// The unterminated OSC is discarded when the ESC interrupts it.
+	assert.Equal(t, []byte(esc("]0;title")+esc("[")), events[1].Raw)
+	assert.Equal(t, "parse", events[1].Kind)
+	assert.Equal(t, 1, events[1].Y)
+	assert.Equal(t, 1, events[1].X)
+	assert.Contains(t, events[1].Err.Error(), "unterminated")
+	assert.Equal(t, "cd        ", string(v.Content[1]))
+
+	// What follows is reported without it.
+	events = nil
+	v.Write([]byte(esc("]0;title") + esc("[7y")))
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, []byte(esc("[7y")), events[1].Raw)
+	}
+}
+
+func TestContentEqual(t *testing.T) {
+	a := NewVT100(2, 5)
+	b := NewVT100(2, 5)
+	a.Write([]byte("ab\r\n" + esc("[1m") + "cd"))
+	b.Write([]byte("ab" + esc("[2;1H") + esc("[1m") + "cd" + esc("[H")))
+
+	assert.NotEqual(t, a.Cursor, b.Cursor)
+	assert.True(t, a.ContentEqual(b))
+	assert.True(t, b.ContentEqual(a))
+	assert.True(t, a.ContentEqual(a))
+
+	// Formats are compared, not just runes.
+	b.Write([]byte(esc("[H") + esc("[3m") + "a"))
+	assert.False(t, a.ContentEqual(b))
+
+	assert.False(t, a.ContentEqual(NewVT100(2, 6)))
+	assert.False(t, a.ContentEqual(NewVT100(3, 5)))
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Fuzz(func(t *testing.T, in []byte) {
+		// The input arrives a byte at a time, so that runes and sequences
+		// are split across reads.
+		r := bytes.NewReader(in)
+		s := bufio.NewReaderSize(iotest.OneByteReader(r), 16)
+		for {
+			before := r.Len() + s.Buffered()
+			cmd, err := Decode(s)
+			if err == io.EOF {
+				return
+			}
+			if err == nil && cmd == nil {
+				t.Fatalf("no command or error decoding %q", in)
+			}
+			if r.Len()+s.Buffered() >= before {
+				t.Fatalf("nothing consumed decoding %q", in)
+			}
+			if err != nil {
+				continue
+			}
+
+			var buf bytes.Buffer
+			assert.Nil(t, cmd.Encode(&buf))
+			again, err := Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil || again != cmd {
+				t.Fatalf("%#v encoded as %q decoded to %#v, %v", cmd, buf.Bytes(), again, err)
+			}
+		}
+	})
+}
+
+func FuzzWrite(f *testing.F) {
+	for _, seed := range []string{
+		"hello\r\nworld",
+		esc("[2147483647B") + esc("[999999999@"),
+		esc("[?6h") + esc("[2;3r") + esc("[?69h") + esc("[2;4s") + esc("[9;9Hx"),
+		esc("]0;unterminated") + "x",
+		esc("[1;31;4:3;38;2;1;2;3m") + "ab\bc\td",
+		esc("(0") + "lqk" + esc("[3\"q") + esc("[?2J"),
+		esc("[38;5;300m") + "a" + esc("[48;5;-1m") + "b" + esc("[38;2;999;0;0m") + "c",
+		esc("[99999;1H") + "x" + esc("[1;99999H") + "y",
+	} {
+		f.Add([]byte(seed), false, false)
+		f.Add([]byte(seed), true, false)
+		f.Add([]byte(seed), false, true)
+	}
+
+	f.Fuzz(func(t *testing.T, in []byte, reflow, autoResize bool) {
+		v, err := New(WithSize(4, 6), WithScrollback(4))
+		assert.Nil(t, err)
+		v.Reflow = reflow
+		// Without limits, each write could grow the terminal by a thousand
+		// rows or columns, which would make it too slow to fuzz.
+		v.AutoResizeY, v.AutoResizeX = autoResize, autoResize
+		v.MaxHeight, v.MaxWidth = 40, 40
+
+		for len(in) > 0 {
+			n := 1 + int(in[0])%16
+			if n > len(in) {
+				n = len(in)
+			}
+			v.Write(in[:n])
+			in = in[n:]
+			if len(in)%7 == 0 {
+				v.Resize(1+len(in)%5, 1+len(in)%9)
+			}
+		}
+
+		if len(v.Content) != v.Height || len(v.Format) != v.Height {
+			t.Fatalf("%d rows of content for height %d", len(v.Content), v.Height)
+		}
+		if len(v.LineWrapped) != v.Height || v.LineWrapped[0] {
+			t.Fatalf("line wrap marks %v for height %d", v.LineWrapped, v.Height)
+		}
+		for y := range v.Content {
+			if len(v.Content[y]) != v.Width || len(v.Format[y]) != v.Width {
+				t.Fatalf("row %d is %d wide for width %d", y, len(v.Content[y]), v.Width)
+			}
+		}
+		v.HTML()
+		v.DebugDump()
+		v.RenderImage(basicfont.Face7x13)
+	})
+}
+
+func TestContentCopy(t *testing.T) {
+	red := Format{Fg: termenv.ANSIRed}
+	v := vttest.FromLinesAndFormats("ab\ncd", [][]Format{
+		{red, {}},
+		{{}, red},
+	})
+
+	content := v.ContentCopy()
+	format := v.FormatCopy()
+	assert.Equal(t, v.Content, content)
+	assert.Equal(t, v.Format, format)
+
+	content[0][0] = 'x'
+	format[1][1] = Format{}
+	assert.Equal(t, 'a', v.Content[0][0])
+	assert.Equal(t, red, v.Format[1][1])
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	v := NewVT100(10, 20)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			fmt.Fprintf(v, "\x1b[3%dmline %d\r\n", i%8, i)
+			if i%50 == 0 {
+				v.Resize(10+i%3, 20)
+			}
+		}
+	}()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				v.HTML()
+				v.UsedHeight()
+				v.Snapshot()
+				v.ContentCopy()
+				v.FormatCopy()
+				v.Hash()
+				v.FindAll("line")
+				v.Line(0)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// BenchmarkHTMLWhileWriting measures HTML with four concurrent readers while
+// another goroutine writes to the terminal as fast as it can.
+func BenchmarkHTMLWhileWriting(b *testing.B) {
+	v := NewVT100(24, 80)
+	line := []byte("\x1b[1;32mhello\x1b[0m world, this is some output\r\n")
+
+	done := make(chan struct{})
+	var writer sync.WaitGroup
+	writer.Add(1)
+	go func() {
+		defer writer.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				v.Write(line)
+			}
+		}
+	}()
+
+	b.ResetTimer()
+
+	const readers = 4
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				v.HTML()
+			}
+		}(b.N/readers + 1)
+	}
+	wg.Wait()
+
+	b.StopTimer()
+	close(done)
+	writer.Wait()
+}
+
+func benchmarkWrite(b *testing.B, chunks [][]byte) {
+	var size int64
+	for _, c := range chunks {
+		size += int64(len(c))
+	}
+	b.SetBytes(size)
+	b.ReportAllocs()
+
+	v := NewVT100(24, 80)
+	for i := 0; i < b.N; i++ {
+		for _, c := range chunks {
+			v.Write(c)
+		}
+	}
+}
+
+func BenchmarkWritePlain(b *testing.B) {
+	line := "the quick brown fox jumps over the lazy dog, 0123456789\r\n"
+	benchmarkWrite(b, [][]byte{[]byte(strings.Repeat(line, 100))})
+}
+
+func BenchmarkWriteSGR(b *testing.B) {
+	line := "\x1b[1;31merror\x1b[0m: \x1b[38;5;208mthing\x1b[0m \x1b[4mfailed\x1b[24m at \x1b[38;2;10;20;30mhere\x1b[m\r\n"
+	benchmarkWrite(b, [][]byte{[]byte(strings.Repeat(line, 100))})
+}
+
+// BenchmarkWriteSplit writes escape-heavy output a few bytes at a time, so
+// that most sequences are split across writes.
+func BenchmarkWriteSplit(b *testing.B) {
+	out := []byte(strings.Repeat("\x1b[1;31merror\x1b[0m: \x1b[2Kfailed\r\n", 20))
+	var chunks [][]byte
+	for len(out) > 0 {
+		n := 3
+		if n > len(out) {
+			n = len(out)
+		}
+		chunks = append(chunks, out[:n])
+		out = out[n:]
+	}
+	benchmarkWrite(b, chunks)
+}
+
+// BenchmarkWriteSmallChunks writes typical output 10 bytes at a time, as a
+// program writing to a pty a little at a time would.
+func BenchmarkWriteSmallChunks(b *testing.B) {
+	out := []byte(strings.Repeat("building \x1b[1mpackage\x1b[0m ok 0.12s\r\n", 20))
+	var chunks [][]byte
+	for len(out) > 0 {
+		n := 10
+		if n > len(out) {
+			n = len(out)
+		}
+		chunks = append(chunks, out[:n])
+		out = out[n:]
+	}
+	benchmarkWrite(b, chunks)
+}
@@ -0,0 +1,74 @@
+package vt100
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// vt52Command is an escape sequence decoded in VT52 mode. For direct cursor
+// addressing, "ESC Y", args holds the row and column, each offset by 32.
+type vt52Command struct {
+	cmd  rune
+	args string
+}
+
+// vt52Names are the mnemonics of the VT52 commands.
+var vt52Names = map[rune]string{
+	'A': "CUU",
+	'B': "CUD",
+	'C': "CUF",
+	'D': "CUB",
+	'H': "HOME",
+	'J': "ED",
+	'K': "EL",
+	'<': "ANSI",
+}
+
+// String describes c, e.g. "VT52(CUU)", or "VT52(DCA(3,1))" for direct
+// cursor addressing, with the row and column 1-indexed like CUP's.
+func (c vt52Command) String() string {
+	if c.cmd == 'Y' {
+		y, x := c.address()
+		return fmt.Sprintf("VT52(DCA(%d,%d))", y+1, x+1)
+	}
+	if name, ok := vt52Names[c.cmd]; ok {
+		return "VT52(" + name + ")"
+	}
+	return fmt.Sprintf("VT52(%q)", c.cmd)
+}
+
+// Encode writes c as a VT52 escape sequence, which only decodes to the same
+// command in VT52 mode.
+func (c vt52Command) Encode(w io.Writer) error {
+	_, err := io.WriteString(w, "\x1b"+string(c.cmd)+c.args)
+	return err
+}
+
+func (c vt52Command) GoString() string {
+	return fmt.Sprintf("vt52Command{%q, %q}", c.cmd, c.args)
+}
+
+// address returns the 0-indexed row and column of an "ESC Y" command.
+func (c vt52Command) address() (y, x int) {
+	r, n := utf8.DecodeRuneInString(c.args)
+	col, _ := utf8.DecodeRuneInString(c.args[n:])
+	return int(r) - 32, int(col) - 32
+}
+
+// display carries out c with the ANSI command that does the same, since
+// VT52 cursor motion and erasing work just like theirs.
+func (c vt52Command) display(v *VT100) error {
+	switch c.cmd {
+	case 'A', 'B', 'C', 'D', 'H', 'J', 'K':
+		return escapeCommand{c.cmd, ""}.display(v)
+	case 'Y':
+		y, x := c.address()
+		v.unwrap()
+		return v.home(y, x)
+	case '<':
+		v.VT52Mode = false
+		return nil
+	}
+	return supportError(fmt.Sprintf("VT52 command %q", c.cmd), nil, fmt.Errorf("%s: unsupported VT52 command", c))
+}
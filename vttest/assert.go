@@ -0,0 +1,66 @@
+package vttest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vito/vt100"
+)
+
+// AssertContent reports an error with t, listing the rows that differ, unless
+// the text of v is expected, as a string of newline-separated rows. Trailing
+// blanks are ignored, as by TrimmedLines, and so are trailing empty rows.
+func AssertContent(t testing.TB, v *vt100.VT100, expected string) {
+	t.Helper()
+
+	got := trimEmpty(v.TrimmedLines())
+	want := trimEmpty(strings.Split(expected, "\n"))
+
+	var diffs []string
+	for y := 0; y < len(got) || y < len(want); y++ {
+		var g, w string
+		if y < len(got) {
+			g = got[y]
+		}
+		if y < len(want) {
+			w = want[y]
+		}
+		if g != w {
+			diffs = append(diffs, fmt.Sprintf("row %d: got %q, want %q", y, g, w))
+		}
+	}
+	if len(diffs) > 0 {
+		t.Errorf("content differs:\n%s\ngot:\n%s", strings.Join(diffs, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+// trimEmpty returns lines without its trailing empty lines.
+func trimEmpty(lines []string) []string {
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// AssertCursor reports an error with t unless v's cursor is at row y and
+// column x.
+func AssertCursor(t testing.TB, v *vt100.VT100, y, x int) {
+	t.Helper()
+
+	c := v.Snapshot().Cursor
+	if c.Y != y || c.X != x {
+		t.Errorf("cursor is at row %d, column %d, want row %d, column %d", c.Y, c.X, y, x)
+	}
+}
+
+// AssertCursorFormat is like AssertCursor, but also reports an error unless
+// the cursor's format is f.
+func AssertCursorFormat(t testing.TB, v *vt100.VT100, y, x int, f vt100.Format) {
+	t.Helper()
+
+	AssertCursor(t, v, y, x)
+	if c := v.Snapshot().Cursor; c.F != f {
+		t.Errorf("cursor format is %q, want %q", c.F, f)
+	}
+}
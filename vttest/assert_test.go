@@ -0,0 +1,48 @@
+package vttest_test
+
+import (
+	"testing"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/vito/vt100"
+	"github.com/vito/vt100/vttest"
+)
+
+func TestAssertContent(t *testing.T) {
+	v := vt100.NewVT100(3, 4)
+	v.Write([]byte("ab\r\ncd"))
+
+	r := &recorder{TB: t}
+	vttest.AssertContent(r, v, "ab\ncd")
+	vttest.AssertContent(r, v, "ab\ncd\n")
+	assert.Empty(t, r.errors)
+
+	vttest.AssertContent(r, v, "ab\nce\nf")
+	if assert.Len(t, r.errors, 1) {
+		assert.Contains(t, r.errors[0], `row 1: got "cd", want "ce"`)
+		assert.Contains(t, r.errors[0], `row 2: got "", want "f"`)
+		assert.NotContains(t, r.errors[0], "row 0")
+	}
+}
+
+func TestAssertCursor(t *testing.T) {
+	v := vt100.NewVT100(3, 4)
+	v.Write([]byte("ab\r\nc\x1b[1m"))
+
+	r := &recorder{TB: t}
+	vttest.AssertCursor(r, v, 1, 1)
+	vttest.AssertCursorFormat(r, v, 1, 1, vt100.Format{Intensity: vt100.Bold})
+	assert.Empty(t, r.errors)
+
+	vttest.AssertCursor(r, v, 0, 2)
+	if assert.Len(t, r.errors, 1) {
+		assert.Equal(t, "cursor is at row 1, column 1, want row 0, column 2", r.errors[0])
+	}
+
+	r.errors = nil
+	vttest.AssertCursorFormat(r, v, 1, 1, vt100.Format{Fg: termenv.ANSIRed})
+	if assert.Len(t, r.errors, 1) {
+		assert.Equal(t, `cursor format is "bold", want "fg=red"`, r.errors[0])
+	}
+}
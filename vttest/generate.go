@@ -7,25 +7,44 @@ import (
 	"github.com/vito/vt100"
 )
 
-// FromLines generates a VT100 from content text.
-// Each line must have the same number of runes.
+// FromLines generates a VT100 from content text. Lines shorter than the
+// longest one are padded with spaces.
 func FromLines(s string) *vt100.VT100 {
 	return FromLinesAndFormats(s, nil)
 }
 
+// FromLinesWidth generates a VT100 of exactly width columns from lines,
+// truncating longer lines and padding shorter ones with spaces.
+func FromLinesWidth(lines []string, width int) *vt100.VT100 {
+	return fromLines(lines, width, nil)
+}
+
 // FromLinesAndFormats generates a *VT100 whose state is set according
 // to s (for content) and a (for attributes).
 //
-// Dimensions are set to the width of s' first line and the height of the
-// number of lines in s.
+// Dimensions are set to the width of s' longest line and the height of the
+// number of lines in s. Shorter lines are padded with spaces.
 //
 // If a is nil, the default attributes are used.
 func FromLinesAndFormats(s string, a [][]vt100.Format) *vt100.VT100 {
 	lines := strings.Split(s, "\n")
-	v := vt100.NewVT100(len(lines), utf8.RuneCountInString(lines[0]))
+	width := 0
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > width {
+			width = n
+		}
+	}
+	return fromLines(lines, width, a)
+}
+
+func fromLines(lines []string, width int, a [][]vt100.Format) *vt100.VT100 {
+	v := vt100.NewVT100(len(lines), width)
 	for y := 0; y < v.Height; y++ {
 		x := 0
 		for _, r := range lines[y] {
+			if x >= width {
+				break
+			}
 			v.Content[y][x] = r
 			if a != nil {
 				v.Format[y][x] = a[y][x]
@@ -0,0 +1,29 @@
+package vttest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vito/vt100/vttest"
+)
+
+func TestFromLines(t *testing.T) {
+	v := vttest.FromLines("ab\nc")
+	assert.Equal(t, 2, v.Height)
+	assert.Equal(t, 2, v.Width)
+	assert.Equal(t, []rune("ab"), v.Content[0])
+	assert.Equal(t, []rune("c "), v.Content[1])
+
+	v = vttest.FromLines("a\nbcd")
+	assert.Equal(t, 3, v.Width)
+	assert.Equal(t, []rune("a  "), v.Content[0])
+	assert.Equal(t, []rune("bcd"), v.Content[1])
+}
+
+func TestFromLinesWidth(t *testing.T) {
+	v := vttest.FromLinesWidth([]string{"abcd", "e"}, 3)
+	assert.Equal(t, 2, v.Height)
+	assert.Equal(t, 3, v.Width)
+	assert.Equal(t, []rune("abc"), v.Content[0])
+	assert.Equal(t, []rune("e  "), v.Content[1])
+}
@@ -0,0 +1,134 @@
+package vttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vito/vt100"
+)
+
+// UpdateGoldenEnv is the environment variable that, when set to "1", makes
+// GoldenFile write golden files rather than compare against them.
+const UpdateGoldenEnv = "VT100_UPDATE_GOLDEN"
+
+// maxGoldenDiffs is the most differing cells that GoldenFile reports.
+const maxGoldenDiffs = 10
+
+// golden is the state of a terminal as kept in a golden file. Each format is
+// described by its String method.
+type golden struct {
+	Height  int        `json:"height"`
+	Width   int        `json:"width"`
+	Cursor  [2]int     `json:"cursor"`
+	Content []string   `json:"content"`
+	Format  [][]string `json:"format"`
+}
+
+func goldenOf(v *vt100.VT100) golden {
+	s := v.Snapshot()
+	g := golden{
+		Height: s.Height,
+		Width:  s.Width,
+		Cursor: [2]int{s.Cursor.Y, s.Cursor.X},
+	}
+	for y, row := range s.Content {
+		g.Content = append(g.Content, string(row))
+		formats := make([]string, len(s.Format[y]))
+		for x, f := range s.Format[y] {
+			formats[x] = f.String()
+		}
+		g.Format = append(g.Format, formats)
+	}
+	return g
+}
+
+// GoldenFile compares the content, formats, dimensions, and cursor of v with
+// those kept in testdata/<name>.golden.json, and reports any differences with
+// t.Errorf. It returns whether they matched.
+//
+// If the environment variable VT100_UPDATE_GOLDEN is set to 1, the file is
+// written with v's state instead, and true is returned.
+func GoldenFile(t testing.TB, name string, v *vt100.VT100) bool {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden.json")
+	got := goldenOf(v)
+
+	if os.Getenv(UpdateGoldenEnv) == "1" {
+		b, err := json.MarshalIndent(got, "", "  ")
+		if err != nil {
+			t.Fatalf("encoding golden file %s: %s", path, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("writing golden file: %s", err)
+		}
+		if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+			t.Fatalf("writing golden file: %s", err)
+		}
+		return true
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (set %s=1 to create it): %s", UpdateGoldenEnv, err)
+	}
+	var want golden
+	if err := json.Unmarshal(b, &want); err != nil {
+		t.Fatalf("decoding golden file %s: %s", path, err)
+	}
+
+	diffs := goldenDiff(got, want)
+	if len(diffs) == 0 {
+		return true
+	}
+	t.Errorf("terminal differs from %s (set %s=1 to update it):\n%s", path, UpdateGoldenEnv, strings.Join(diffs, "\n"))
+	return false
+}
+
+// goldenDiff describes the differences between got and want, one per line.
+func goldenDiff(got, want golden) []string {
+	var diffs []string
+	if got.Height != want.Height || got.Width != want.Width {
+		diffs = append(diffs, fmt.Sprintf("size is %dx%d, want %dx%d", got.Height, got.Width, want.Height, want.Width))
+	}
+	if got.Cursor != want.Cursor {
+		diffs = append(diffs, fmt.Sprintf("cursor is at row %d, column %d, want row %d, column %d", got.Cursor[0], got.Cursor[1], want.Cursor[0], want.Cursor[1]))
+	}
+
+	cells := 0
+	for y := 0; y < len(got.Content) && y < len(want.Content); y++ {
+		gotRow, wantRow := []rune(got.Content[y]), []rune(want.Content[y])
+		for x := 0; x < len(gotRow) && x < len(wantRow); x++ {
+			var diff string
+			if gotRow[x] != wantRow[x] {
+				diff = fmt.Sprintf("row %d, column %d: rune %q, want %q", y, x, gotRow[x], wantRow[x])
+			} else if gotF, wantF := cell(got.Format, y, x), cell(want.Format, y, x); gotF != wantF {
+				diff = fmt.Sprintf("row %d, column %d: format %q, want %q", y, x, gotF, wantF)
+			} else {
+				continue
+			}
+
+			cells++
+			if cells <= maxGoldenDiffs {
+				diffs = append(diffs, diff)
+			}
+		}
+	}
+	if cells > maxGoldenDiffs {
+		diffs = append(diffs, fmt.Sprintf("... and %d more cells", cells-maxGoldenDiffs))
+	}
+	return diffs
+}
+
+// cell returns formats[y][x], or "" if there's no such cell.
+func cell(formats [][]string, y, x int) string {
+	if y >= len(formats) || x >= len(formats[y]) {
+		return ""
+	}
+	return formats[y][x]
+}
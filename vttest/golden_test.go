@@ -0,0 +1,63 @@
+package vttest_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/muesli/termenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/vito/vt100"
+	"github.com/vito/vt100/vttest"
+)
+
+// recorder is a testing.TB that keeps the errors reported to it.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestGoldenFile(t *testing.T) {
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(t.TempDir()))
+	defer os.Chdir(wd)
+
+	red := vt100.Format{Fg: termenv.ANSIRed}
+	v := vttest.FromLinesAndFormats("abc\ndef", [][]vt100.Format{
+		{{}, red, {}},
+		{{}, {}, {}},
+	})
+
+	t.Setenv(vttest.UpdateGoldenEnv, "1")
+	r := &recorder{TB: t}
+	assert.True(t, vttest.GoldenFile(r, "screen", v))
+	assert.Empty(t, r.errors)
+	_, err = os.Stat(filepath.Join("testdata", "screen.golden.json"))
+	assert.Nil(t, err)
+
+	t.Setenv(vttest.UpdateGoldenEnv, "")
+	assert.True(t, vttest.GoldenFile(r, "screen", v))
+	assert.Empty(t, r.errors)
+
+	v.Content[1][2] = 'x'
+	assert.False(t, vttest.GoldenFile(r, "screen", v))
+	if assert.Len(t, r.errors, 1) {
+		assert.Contains(t, r.errors[0], `row 1, column 2: rune 'x', want 'f'`)
+	}
+
+	v.Content[1][2] = 'f'
+	v.Format[0][1] = vt100.Format{}
+	r.errors = nil
+	assert.False(t, vttest.GoldenFile(r, "screen", v))
+	if assert.Len(t, r.errors, 1) {
+		assert.Contains(t, r.errors[0], `row 0, column 1: format "", want "fg=red"`)
+	}
+}
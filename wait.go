@@ -0,0 +1,83 @@
+package vt100
+
+import (
+	"context"
+	"strings"
+)
+
+// WaitFor blocks until pred returns true or ctx is done, returning ctx's error
+// in the latter case. pred is called with a snapshot of the terminal once
+// immediately, and again every time the terminal changes.
+func (v *VT100) WaitFor(ctx context.Context, pred func(*Snapshot) bool) error {
+	for {
+		v.mut.Lock()
+		s := v.snapshot()
+		if v.updated == nil {
+			v.updated = make(chan struct{})
+		}
+		updated := v.updated
+		v.mut.Unlock()
+
+		if pred(s) {
+			return nil
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WaitForText blocks until text appears on a row of the terminal or ctx is
+// done.
+func (v *VT100) WaitForText(ctx context.Context, text string) error {
+	return v.WaitFor(ctx, func(s *Snapshot) bool {
+		for _, row := range s.Content {
+			if strings.Contains(string(row), text) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Updates returns a channel that receives a value whenever Write, Process,
+// Resize, or another exported method changes the terminal.
+//
+// The channel has room for one pending notification and is never blocked on,
+// so any number of changes made before the receiver gets to it coalesce into a
+// single notification. A notification is sent only once the change that
+// caused it is complete, so the terminal read after receiving always reflects
+// at least that change (and possibly later ones).
+//
+// Every call returns the same channel.
+func (v *VT100) Updates() <-chan struct{} {
+	v.mut.Lock()
+	defer v.mut.Unlock()
+
+	if v.updates == nil {
+		v.updates = make(chan struct{}, 1)
+	}
+	return v.updates
+}
+
+// notify wakes up everyone waiting for the terminal to change. It must be
+// called with v.mut held.
+func (v *VT100) notify() {
+	v.invalidateHash()
+
+	if v.updated != nil {
+		close(v.updated)
+		v.updated = nil
+	}
+
+	if v.updates != nil {
+		select {
+		case v.updates <- struct{}{}:
+		default:
+			// There's already a notification pending.
+		}
+	}
+}
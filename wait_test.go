@@ -0,0 +1,91 @@
+package vt100_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	. "github.com/vito/vt100"
+)
+
+func TestWaitFor(t *testing.T) {
+	v := NewVT100(2, 10)
+
+	go func() {
+		for _, s := range []string{"hel", "lo, ", "wor", "ld"} {
+			time.Sleep(5 * time.Millisecond)
+			v.Write([]byte(s))
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var seen *Snapshot
+	assert.Nil(t, v.WaitFor(ctx, func(s *Snapshot) bool {
+		seen = s
+		return s.Cursor.Y == 1 && s.Cursor.X == 2
+	}))
+	assert.Equal(t, "hello, wor", string(seen.Content[0]))
+	assert.Equal(t, "ld        ", string(seen.Content[1]))
+}
+
+func TestWaitForText(t *testing.T) {
+	v := NewVT100(2, 10)
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		v.Write([]byte("ready\r\n"))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, v.WaitForText(ctx, "ready"))
+}
+
+func TestWaitForCancel(t *testing.T) {
+	v := NewVT100(2, 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, v.WaitForText(ctx, "never"))
+}
+
+func TestUpdates(t *testing.T) {
+	v := NewVT100(2, 10)
+	updates := v.Updates()
+
+	renders := 0
+	drain := func() {
+		for {
+			select {
+			case <-updates:
+				renders++
+			default:
+				return
+			}
+		}
+	}
+
+	// A burst of writes coalesces into one pending update.
+	for i := 0; i < 100; i++ {
+		v.Write([]byte("x"))
+	}
+	drain()
+	assert.Equal(t, 1, renders)
+
+	// Nothing changed, so nothing to render.
+	v.Write(nil)
+	v.Resize(2, 10)
+	drain()
+	assert.Equal(t, 1, renders)
+
+	v.Resize(3, 10)
+	drain()
+	assert.Equal(t, 2, renders)
+
+	v.Process(cmd("y"))
+	drain()
+	assert.Equal(t, 3, renders)
+}